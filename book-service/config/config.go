@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
 
 type Config struct {
 	ServerAddress string
@@ -10,6 +15,28 @@ type Config struct {
 	DBPassword    string
 	DBName        string
 	JWTSecret     string
+	ServiceToken  string
+
+	// PreStopDelay is how long /readyz fails on SIGTERM before the
+	// listener closes, so load balancers notice first
+	PreStopDelay time.Duration
+	// ShutdownTimeout is how long requests in flight get to finish on SIGTERM
+	ShutdownTimeout time.Duration
+
+	// GET /books/stream: events kept per user for Last-Event-ID resume,
+	// for how long, and how often idle streams get a keep-alive comment
+	StreamReplaySize int
+	StreamReplayTTL  time.Duration
+	StreamHeartbeat  time.Duration
+
+	// IdempotencyTTL is how long responses to requests with an
+	// Idempotency-Key are kept for replay
+	IdempotencyTTL time.Duration
+
+	// AuthMode is "jwt" (verify the bearer token) or "gateway" (trust the
+	// identity headers set by the gateway, signed with IdentitySecret)
+	AuthMode       string
+	IdentitySecret string
 }
 
 func Load() Config {
@@ -21,6 +48,19 @@ func Load() Config {
 		DBPassword:    getEnv("DB_PASSWORD", "Password_123"),
 		DBName:        getEnv("DB_NAME", "bookdb"),
 		JWTSecret:     getEnv("JWT_SECRET", "your_jwt_secret"),
+		ServiceToken:  getEnv("SERVICE_TOKEN", ""),
+
+		PreStopDelay:    getDuration("PRESTOP_DELAY", 5*time.Second),
+		ShutdownTimeout: getDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+
+		StreamReplaySize: getInt("STREAM_REPLAY_SIZE", 100),
+		StreamReplayTTL:  getDuration("STREAM_REPLAY_TTL", 5*time.Minute),
+		StreamHeartbeat:  getDuration("STREAM_HEARTBEAT", 15*time.Second),
+
+		IdempotencyTTL: getDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+		AuthMode:       getEnv("AUTH_MODE", "jwt"),
+		IdentitySecret: getEnv("GATEWAY_IDENTITY_SECRET", ""),
 	}
 }
 
@@ -30,3 +70,27 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return n
+}
@@ -24,6 +24,9 @@ func Connect(cfg config.Config) *gorm.DB {
 	if err != nil {
 		log.Fatalf("[DB ERROR] failed to connect: %v", err)
 	}
+	if err := db.Use(newTracingPlugin()); err != nil {
+		log.Fatalf("[DB ERROR] failed to install tracing: %v", err)
+	}
 
 	return db
 }
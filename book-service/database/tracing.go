@@ -0,0 +1,83 @@
+package database
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// tracingPlugin records a span for every GORM operation run with a
+// context that is part of a trace (repositories pass the request context
+// with WithContext). Queries outside a request, like AutoMigrate, are not
+// traced.
+type tracingPlugin struct {
+	tracer trace.Tracer
+}
+
+func (tracingPlugin) Name() string { return "tracing" }
+
+func (p tracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register("tracing:before_create", p.before("create")),
+		cb.Create().After("gorm:create").Register("tracing:after_create", p.after),
+		cb.Query().Before("gorm:query").Register("tracing:before_query", p.before("query")),
+		cb.Query().After("gorm:query").Register("tracing:after_query", p.after),
+		cb.Update().Before("gorm:update").Register("tracing:before_update", p.before("update")),
+		cb.Update().After("gorm:update").Register("tracing:after_update", p.after),
+		cb.Delete().Before("gorm:delete").Register("tracing:before_delete", p.before("delete")),
+		cb.Delete().After("gorm:delete").Register("tracing:after_delete", p.after),
+		cb.Row().Before("gorm:row").Register("tracing:before_row", p.before("row")),
+		cb.Row().After("gorm:row").Register("tracing:after_row", p.after),
+		cb.Raw().Before("gorm:raw").Register("tracing:before_raw", p.before("raw")),
+		cb.Raw().After("gorm:raw").Register("tracing:after_raw", p.after),
+	}
+	return errors.Join(errs...)
+}
+
+func (p tracingPlugin) before(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+		ctx, span := p.tracer.Start(ctx, "db."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(op),
+		))
+		db.Statement.Context = ctx
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func (p tracingPlugin) after(db *gorm.DB) {
+	v, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		semconv.DBQueryText(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if table := db.Statement.Table; table != "" {
+		span.SetAttributes(semconv.DBCollectionName(table))
+	}
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
+
+func newTracingPlugin() gorm.Plugin {
+	return tracingPlugin{tracer: otel.Tracer("book-service/database")}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"book-service/internal/models"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxBatchOperations bounds one POST /books/batch.
+const maxBatchOperations = 100
+
+// BatchBooks applies a list of creates, updates and deletes of the
+// caller's books with the rules of the single-item endpoints. In "atomic"
+// mode (the default) they run in one transaction: the first failing
+// operation rolls all back and its error is returned with its index. In
+// "best_effort" mode each applies on its own, and the response holds one
+// result per operation, in order.
+func (h *BookHandler) BatchBooks(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	var body struct {
+		Mode       string                  `json:"mode"`
+		Operations []models.BatchOperation `json:"operations"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	switch body.Mode {
+	case "":
+		body.Mode = models.BatchAtomic
+	case models.BatchAtomic, models.BatchBestEffort:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be atomic or best_effort"})
+		return
+	}
+	if len(body.Operations) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "operations are required"})
+		return
+	}
+	if len(body.Operations) > maxBatchOperations {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("at most %d operations per request", maxBatchOperations)})
+		return
+	}
+
+	atomic := body.Mode == models.BatchAtomic
+	outcomes, err := h.bookService.ApplyBatch(c.Request.Context(), userUUID, atomic, body.Operations)
+	if err != nil {
+		status, msg := bookErrorStatus(err, "Failed to apply batch")
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+	results := make([]models.BatchResult, len(outcomes))
+	for i, o := range outcomes {
+		if o.Err != nil {
+			status, msg := bookErrorStatus(o.Err, "Failed to apply operation")
+			if atomic {
+				c.JSON(status, gin.H{"error": msg, "index": i})
+				return
+			}
+			results[i] = models.BatchResult{ID: body.Operations[i].ID, Status: status, Error: msg}
+			continue
+		}
+		results[i] = models.BatchResult{ID: o.Book.ID, Status: http.StatusOK}
+		switch body.Operations[i].Op {
+		case models.OpCreate:
+			results[i].Status, results[i].Book = http.StatusCreated, &o.Book
+		case models.OpUpdate:
+			results[i].Book = &o.Book
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
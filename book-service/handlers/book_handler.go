@@ -3,9 +3,13 @@ package handlers
 import (
 	"book-service/internal/models"
 	"book-service/internal/services"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -13,10 +17,11 @@ import (
 
 type BookHandler struct {
 	bookService *services.BookService
+	heartbeat   time.Duration // keep-alive interval of change streams
 }
 
-func NewBookHandler(bookSrv *services.BookService) *BookHandler {
-	return &BookHandler{bookService: bookSrv}
+func NewBookHandler(bookSrv *services.BookService, heartbeat time.Duration) *BookHandler {
+	return &BookHandler{bookService: bookSrv, heartbeat: heartbeat}
 }
 
 func (h *BookHandler) CreateBook(c *gin.Context) {
@@ -25,102 +30,213 @@ func (h *BookHandler) CreateBook(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
-	userID, exists := c.Get("userID") // get from auth middleware
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	userUUID, ok := requestUser(c)
+	if !ok {
 		return
 	}
+	book.UserID = userUUID
 
-	book.UserID = uuid.MustParse(userID.(string))
-
-	id, err := h.bookService.CreateBook(book)
+	id, err := h.bookService.CreateBook(c.Request.Context(), book)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create book"})
+		status, msg := bookErrorStatus(err, "Failed to create book")
+		c.JSON(status, gin.H{"error": msg})
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
 func (h *BookHandler) GetBooks(c *gin.Context) {
-	userIDStr, exists := c.Get("userID")
-	if !exists {
+	userUUID, ok := requestUser(c)
+	if !ok {
+		return
+	}
+
+	books, err := h.bookService.GetBooks(c.Request.Context(), userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch books"})
+		return
+	}
+	c.JSON(http.StatusOK, books)
+}
+
+// GetSummary returns counts per reading status, the books being read and
+// recent changes. ?limit bounds both lists (default 5, at most 50).
+func (h *BookHandler) GetSummary(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "5"))
+	if err != nil || limit < 1 || limit > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+		return
+	}
 
-	userUUID, err := uuid.Parse(userIDStr.(string))
+	summary, err := h.bookService.GetSummary(c.Request.Context(), userUUID, limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch summary"})
 		return
 	}
+	c.JSON(http.StatusOK, summary)
+}
 
-	// fetch all books
-	books, err := h.bookService.GetBooks(userUUID)
+// StreamBooks pushes created, updated and deleted events for the caller's
+// books as Server-Sent Events. A reconnecting client sends Last-Event-ID
+// and first gets the events it missed; if those are no longer buffered it
+// gets a "reset" event instead and should reload its books. Otherwise a
+// "ready" event follows the replay. Both carry the current event ID, so a
+// client that reconnects before any change can still resume.
+func (h *BookHandler) StreamBooks(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("userID"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch books"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
-	// filter books by logged-in user
-	var userBooks []models.Book
-	for _, b := range books {
-		if b.UserID == userUUID {
-			userBooks = append(userBooks, b)
+	sub, replay, complete := h.bookService.Subscribe(userUUID, c.GetHeader("Last-Event-ID"))
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // keep nginx-style proxies from buffering
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !complete {
+		writeEvent(w, sub.Cursor, "reset", []byte("{}"))
+	} else {
+		for _, ev := range replay {
+			writeEvent(w, ev.ID, ev.Type, ev.Data)
 		}
+		writeEvent(w, sub.Cursor, "ready", []byte("{}"))
 	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case ev, ok := <-sub.Events():
+			if !ok {
+				// too far behind or shutting down; the client resumes
+				return
+			}
+			writeEvent(w, ev.ID, ev.Type, ev.Data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		w.Flush()
+	}
+}
 
-	log.Printf("Books count : %d", len(userBooks))
-
-	c.JSON(http.StatusOK, userBooks)
+// writeEvent writes one SSE event; data is single-line JSON.
+func writeEvent(w io.Writer, id, typ string, data []byte) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, typ, data)
 }
 
 func (h *BookHandler) GetBook(c *gin.Context) {
-	idStr := c.Param("id")
-	idInt, err := strconv.Atoi(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+	userUUID, id, ok := bookRequest(c)
+	if !ok {
 		return
 	}
-	id := uint(idInt)
-	book, err := h.bookService.GetBook(id)
+	book, err := h.bookService.GetBook(c.Request.Context(), userUUID, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		status, msg := bookErrorStatus(err, "Failed to fetch book")
+		c.JSON(status, gin.H{"error": msg})
 		return
 	}
 	c.JSON(http.StatusOK, book)
 }
 
 func (h *BookHandler) UpdateBook(c *gin.Context) {
-	idStr := c.Param("id")
-	idInt, err := strconv.Atoi(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+	userUUID, id, ok := bookRequest(c)
+	if !ok {
 		return
 	}
-	id := uint(idInt)
 	var book models.Book
 	if err := c.ShouldBindJSON(&book); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
-	if err := h.bookService.UpdateBook(id, book); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+	if err := h.bookService.UpdateBook(c.Request.Context(), userUUID, id, book); err != nil {
+		status, msg := bookErrorStatus(err, "Failed to update book")
+		c.JSON(status, gin.H{"error": msg})
 		return
 	}
 	c.Status(http.StatusOK)
 }
 
 func (h *BookHandler) DeleteBook(c *gin.Context) {
-	idStr := c.Param("id")
-	idInt, err := strconv.Atoi(idStr)
+	userUUID, id, ok := bookRequest(c)
+	if !ok {
+		return
+	}
+	if err := h.bookService.DeleteBook(c.Request.Context(), userUUID, id); err != nil {
+		status, msg := bookErrorStatus(err, "Failed to delete book")
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// requestUser reads the caller set by the auth middleware, answering 401
+// itself when it is missing or malformed.
+func requestUser(c *gin.Context) (uuid.UUID, bool) {
+	userUUID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return uuid.Nil, false
+	}
+	return userUUID, true
+}
+
+// bookRequest reads the caller and the :id parameter, answering the
+// request itself when either is missing or malformed.
+func bookRequest(c *gin.Context) (uuid.UUID, uint, bool) {
+	userUUID, ok := requestUser(c)
+	if !ok {
+		return uuid.Nil, 0, false
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return uuid.Nil, 0, false
+	}
+	return userUUID, uint(id), true
+}
+
+// bookErrorStatus maps an error of a book operation to a response status
+// and message; internal errors are logged and answered with fallback.
+func bookErrorStatus(err error, fallback string) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidBook):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, services.ErrBookNotFound):
+		return http.StatusNotFound, "Book not found"
+	}
+	log.Printf("%s: %v", fallback, err)
+	return http.StatusInternalServerError, fallback
+}
+
+// ExportUserBooks returns every book owned by the given user. It is mounted
+// on the internal, service-authenticated router and backs the personal data
+// export in user-service.
+func (h *BookHandler) ExportUserBooks(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
-	id := uint(idInt)
-	if err := h.bookService.DeleteBook(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete book"})
+
+	books, err := h.bookService.GetBooks(c.Request.Context(), userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch books"})
 		return
 	}
-	c.Status(http.StatusOK)
+
+	c.JSON(http.StatusOK, books)
 }
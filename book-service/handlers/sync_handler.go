@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"book-service/internal/models"
+	"book-service/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxSyncMutations bounds one POST /sync; larger queues are sent in parts.
+const maxSyncMutations = 500
+
+// GetSync returns the caller's books changed since ?since=<token>, or all
+// of them without one, with the token for the next sync. ?limit bounds
+// the page (default 500, at most 1000); has_more asks for another call.
+func (h *BookHandler) GetSync(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	changes, err := h.bookService.Changes(c.Request.Context(), userUUID, c.Query("since"), limit)
+	if errors.Is(err, services.ErrInvalidSyncToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch changes"})
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
+
+// PostSync applies mutations queued by an offline client and returns one
+// result per mutation, in order. "strategy" picks how conflicts resolve:
+// lww (default) or reject.
+func (h *BookHandler) PostSync(c *gin.Context) {
+	userUUID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	var body struct {
+		Strategy  string                `json:"strategy"`
+		Mutations []models.SyncMutation `json:"mutations"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	switch body.Strategy {
+	case "":
+		body.Strategy = models.SyncLastWriterWins
+	case models.SyncLastWriterWins, models.SyncReject:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "strategy must be lww or reject"})
+		return
+	}
+	if len(body.Mutations) > maxSyncMutations {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("at most %d mutations per request", maxSyncMutations)})
+		return
+	}
+
+	results := h.bookService.ApplySync(c.Request.Context(), userUUID, body.Strategy, body.Mutations)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of book change events.
+const (
+	BookCreated = "created"
+	BookUpdated = "updated"
+	BookDeleted = "deleted"
+)
+
+// Event is one change to a user's library. IDs are "<boot>-<seq>": seq
+// increases with every event of the broker, and boot changes with every
+// process start so that IDs from before a restart are never mistaken for
+// new ones.
+type Event struct {
+	ID   string
+	Type string
+	Data json.RawMessage
+	At   time.Time
+
+	seq uint64
+}
+
+type Config struct {
+	ReplaySize       int           // events kept per user for Last-Event-ID
+	ReplayTTL        time.Duration // and for how long
+	SubscriberBuffer int           // a subscriber further behind is dropped
+}
+
+// Broker fans book changes out to the open streams of their owner. It is
+// in-process: a user's streams and writes must reach the same instance,
+// which the gateway's consistent hashing on the user provides.
+type Broker struct {
+	cfg  Config
+	boot string
+
+	mu     sync.Mutex
+	seq    uint64
+	users  map[uuid.UUID]*userStream
+	closed bool
+}
+
+type userStream struct {
+	replay []Event // oldest first
+	pruned uint64  // events up to this seq may have been dropped
+	subs   map[*Subscription]struct{}
+}
+
+// Subscription receives the events of one user until it is closed, or
+// until it falls more than SubscriberBuffer events behind, in which case
+// Events is closed and the client should reconnect with Last-Event-ID.
+type Subscription struct {
+	// Cursor is the ID of the broker's latest event when the subscription
+	// started; replayed events are at or before it, live ones after.
+	Cursor string
+
+	broker *Broker
+	userID uuid.UUID
+	ch     chan Event
+	once   sync.Once
+}
+
+func NewBroker(cfg Config) *Broker {
+	var b [4]byte
+	rand.Read(b[:])
+	return &Broker{cfg: cfg, boot: hex.EncodeToString(b[:]), users: map[uuid.UUID]*userStream{}}
+}
+
+// Publish records an event for userID and sends it to their subscribers.
+func (b *Broker) Publish(userID uuid.UUID, typ string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	u := b.user(userID)
+	b.seq++
+	ev := Event{ID: fmt.Sprintf("%s-%d", b.boot, b.seq), Type: typ, Data: raw, At: time.Now(), seq: b.seq}
+	u.replay = append(u.replay, ev)
+	b.prune(u, ev.At)
+
+	for sub := range u.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			delete(u.subs, sub)
+			sub.once.Do(func() { close(sub.ch) })
+		}
+	}
+	return nil
+}
+
+// Subscribe opens a subscription for userID. When lastEventID is set, the
+// events after it are returned for replay; complete is false when they
+// are no longer all buffered (or the ID is from before a restart), and the
+// client has to reload the library instead.
+func (b *Broker) Subscribe(userID uuid.UUID, lastEventID string) (sub *Subscription, replay []Event, complete bool) {
+	sub = &Subscription{broker: b, userID: userID, ch: make(chan Event, b.cfg.SubscriberBuffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.ch)
+		return sub, nil, true
+	}
+	u := b.user(userID)
+	u.subs[sub] = struct{}{}
+	sub.Cursor = fmt.Sprintf("%s-%d", b.boot, b.seq)
+	b.prune(u, time.Now())
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+
+	boot, seqStr, _ := strings.Cut(lastEventID, "-")
+	last, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil || boot != b.boot || last > b.seq || last < u.pruned {
+		return sub, nil, false
+	}
+	for _, ev := range u.replay {
+		if ev.seq > last {
+			replay = append(replay, ev)
+		}
+	}
+	return sub, replay, true
+}
+
+// Events delivers the subscription's events.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Close() {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if u, ok := b.users[s.userID]; ok {
+		delete(u.subs, s)
+	}
+	s.once.Do(func() { close(s.ch) })
+}
+
+// Close ends every subscription, e.g. on shutdown, so open streams return.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, u := range b.users {
+		for sub := range u.subs {
+			sub.once.Do(func() { close(sub.ch) })
+		}
+		u.subs = nil
+	}
+}
+
+// RunCleanup periodically drops expired replay events and idle users.
+func (b *Broker) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			for id, u := range b.users {
+				b.prune(u, now)
+				if len(u.replay) == 0 && len(u.subs) == 0 {
+					delete(b.users, id)
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// user returns the stream of id. A new (or forgotten) user can't resume
+// from before its creation since earlier events are unknown.
+func (b *Broker) user(id uuid.UUID) *userStream {
+	u, ok := b.users[id]
+	if !ok {
+		u = &userStream{pruned: b.seq, subs: map[*Subscription]struct{}{}}
+		b.users[id] = u
+	}
+	return u
+}
+
+// prune keeps at most ReplaySize events no older than ReplayTTL.
+func (b *Broker) prune(u *userStream, now time.Time) {
+	drop := max(len(u.replay)-b.cfg.ReplaySize, 0)
+	for drop < len(u.replay) && now.Sub(u.replay[drop].At) > b.cfg.ReplayTTL {
+		drop++
+	}
+	if drop > 0 {
+		u.pruned = u.replay[drop-1].seq
+		u.replay = u.replay[drop:]
+	}
+}
@@ -7,14 +7,134 @@ import (
 	"gorm.io/gorm"
 )
 
+// Reading states of a book.
+const (
+	StatusWantToRead = "want_to_read"
+	StatusReading    = "reading"
+	StatusFinished   = "finished"
+)
+
+// ValidStatus reports whether status is one of the reading states.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusWantToRead, StatusReading, StatusFinished:
+		return true
+	}
+	return false
+}
+
 type Book struct {
 	ID          uint   `gorm:"primaryKey" json:"id"`
 	Title       string `gorm:"type:varchar(255);not null" json:"title"`
 	Author      string `gorm:"type:varchar(255);not null" json:"author"`
 	Description string `gorm:"type:text" json:"description"`
 	Year        int    `gorm:"type:int" json:"year"`
+	Status      string `gorm:"type:varchar(20);not null;default:want_to_read;index" json:"status"`
 	UserID      uuid.UUID
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	DeletedAt   gorm.DeletedAt `gorm:"index"`
 }
+
+// BookActivity is a recent change to one of a user's books.
+type BookActivity struct {
+	BookID uint      `json:"book_id"`
+	Title  string    `json:"title"`
+	Action string    `json:"action"` // added, updated or removed
+	At     time.Time `json:"at"`
+}
+
+// BookSummary is an overview of a user's library.
+type BookSummary struct {
+	Total            int64            `json:"total"`
+	ByStatus         map[string]int64 `json:"by_status"`
+	CurrentlyReading []Book           `json:"currently_reading"`
+	RecentActivity   []BookActivity   `json:"recent_activity"`
+}
+
+// BookTombstone marks a book deleted since a sync token.
+type BookTombstone struct {
+	ID        uint      `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// SyncChanges is one page of changes since a sync token. Pass NextToken
+// as ?since= next time; while HasMore is set, there are more right away.
+type SyncChanges struct {
+	Changes   []Book          `json:"changes"`
+	Deleted   []BookTombstone `json:"deleted"`
+	NextToken string          `json:"next_token"`
+	HasMore   bool            `json:"has_more"`
+}
+
+// Conflict strategies of POST /sync.
+const (
+	SyncLastWriterWins = "lww"
+	SyncReject         = "reject"
+)
+
+// Operations of POST /sync and POST /books/batch.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Sync result statuses.
+const (
+	SyncApplied  = "applied"
+	SyncConflict = "conflict"
+	SyncNotFound = "not_found"
+	SyncInvalid  = "invalid"
+	SyncError    = "error"
+)
+
+// SyncMutation is a change a client made offline. BaseUpdatedAt is the
+// server's UpdatedAt of the copy the client edited; ModifiedAt is when
+// the client made the change and decides last-writer-wins conflicts.
+type SyncMutation struct {
+	ClientID      string     `json:"client_id"` // echoed back, e.g. to map a local ID to the new one
+	Op            string     `json:"op"`
+	ID            uint       `json:"id"`
+	BaseUpdatedAt *time.Time `json:"base_updated_at"`
+	ModifiedAt    time.Time  `json:"modified_at"`
+	Book          Book       `json:"book"`
+}
+
+// SyncResult is the outcome of one mutation. Book is the server copy:
+// the new state when applied, the winning one on conflict; Deleted is set
+// when that copy is a tombstone.
+type SyncResult struct {
+	ClientID string `json:"client_id,omitempty"`
+	ID       uint   `json:"id,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Book     *Book  `json:"book,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// Modes of POST /books/batch.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+// BatchOperation is one create, update or delete of a batch. ID names the
+// book to update or delete. Book holds the new book, or for an update the
+// fields to change: as with PUT /books/:id, zero values are left as they
+// are.
+type BatchOperation struct {
+	Op   string `json:"op"`
+	ID   uint   `json:"id,omitempty"`
+	Book Book   `json:"book"`
+}
+
+// BatchResult is the outcome of one operation, with the HTTP status the
+// single-item endpoint would have answered. Book is the stored state after
+// a create or update.
+type BatchResult struct {
+	ID     uint   `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Book   *Book  `json:"book,omitempty"`
+}
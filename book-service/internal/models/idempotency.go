@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// IdempotencyKey is the table behind idempotency.Record; the two convert
+// into each other, so the fields must stay in step.
+type IdempotencyKey struct {
+	Scope       string `gorm:"primaryKey;type:varchar(64)"` // the user, or "ip:" and the client IP
+	Key         string `gorm:"primaryKey;type:varchar(255)"`
+	Fingerprint string `gorm:"type:char(64);not null"` // HMAC-SHA256 of method, path and body
+	Status      int    `gorm:"not null;default:0"`
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index;not null"`
+}
@@ -2,33 +2,112 @@ package repository
 
 import (
 	"book-service/internal/models"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func (r *bookGorm) Create(book models.Book) (uint, error) {
-	result := r.db.Create(&book)
+func (r *bookGorm) Create(ctx context.Context, book models.Book) (uint, error) {
+	result := r.db.WithContext(ctx).Create(&book)
 	return book.ID, result.Error
 }
 
-func (r *bookGorm) GetAll(userID uuid.UUID) ([]models.Book, error) {
+func (r *bookGorm) GetAll(ctx context.Context, userID uuid.UUID) ([]models.Book, error) {
 	var books []models.Book
-	result := r.db.Where("user_id = ?", userID).Find(&books)
+	result := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&books)
 	return books, result.Error
 }
 
-func (r *bookGorm) GetByID(id uint) (models.Book, error) {
+func (r *bookGorm) GetByID(ctx context.Context, id uint) (models.Book, error) {
 	var book models.Book
-	result := r.db.First(&book, id)
+	result := r.db.WithContext(ctx).First(&book, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return book, ErrBookNotFound
+	}
 	return book, result.Error
 }
 
-func (r *bookGorm) Update(id uint, book models.Book) error {
-	result := r.db.Model(&models.Book{}).Where("id = ?", id).Updates(book)
+func (r *bookGorm) Update(ctx context.Context, id uint, book models.Book) error {
+	result := r.db.WithContext(ctx).Model(&models.Book{}).Where("id = ?", id).Updates(book)
 	return result.Error
 }
 
-func (r *bookGorm) Delete(id uint) error {
-	result := r.db.Delete(&models.Book{}, id)
+func (r *bookGorm) Delete(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&models.Book{}, id)
 	return result.Error
 }
+
+func (r *bookGorm) CountByStatus(ctx context.Context, userID uuid.UUID) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	result := r.db.WithContext(ctx).Model(&models.Book{}).
+		Select("status, count(*) as count").
+		Where("user_id = ?", userID).
+		Group("status").
+		Scan(&rows)
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, result.Error
+}
+
+func (r *bookGorm) ListByStatus(ctx context.Context, userID uuid.UUID, status string, limit int) ([]models.Book, error) {
+	var books []models.Book
+	result := r.db.WithContext(ctx).
+		Where("user_id = ? AND status = ?", userID, status).
+		Order("updated_at DESC").
+		Limit(limit).
+		Find(&books)
+	return books, result.Error
+}
+
+func (r *bookGorm) ListRecent(ctx context.Context, userID uuid.UUID, limit int) ([]models.Book, error) {
+	var books []models.Book
+	result := r.db.WithContext(ctx).Unscoped().
+		Where("user_id = ?", userID).
+		Order("COALESCE(deleted_at, updated_at) DESC").
+		Limit(limit).
+		Find(&books)
+	return books, result.Error
+}
+
+func (r *bookGorm) ListChanges(ctx context.Context, userID uuid.UUID, since time.Time, afterID uint, limit int) ([]models.Book, error) {
+	var books []models.Book
+	result := r.db.WithContext(ctx).Unscoped().
+		Where("user_id = ?", userID).
+		Where("COALESCE(deleted_at, updated_at) > ? OR (COALESCE(deleted_at, updated_at) = ? AND id > ?)", since, since, afterID).
+		Order("COALESCE(deleted_at, updated_at), id").
+		Limit(limit).
+		Find(&books)
+	return books, result.Error
+}
+
+func (r *bookGorm) GetForUpdate(ctx context.Context, id uint) (models.Book, error) {
+	var book models.Book
+	result := r.db.WithContext(ctx).Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&book, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return book, ErrBookNotFound
+	}
+	return book, result.Error
+}
+
+func (r *bookGorm) Replace(ctx context.Context, book models.Book) (models.Book, error) {
+	book.UpdatedAt = time.Now()
+	result := r.db.WithContext(ctx).Model(&book).
+		Select("title", "author", "description", "year", "status", "updated_at").
+		Updates(&book)
+	return book, result.Error
+}
+
+func (r *bookGorm) Transaction(ctx context.Context, fn func(repo BookRepository) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&bookGorm{db: tx})
+	})
+}
@@ -2,17 +2,38 @@ package repository
 
 import (
 	"book-service/internal/models"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+var ErrBookNotFound = errors.New("book not found")
+
 type BookRepository interface {
-	Create(book models.Book) (uint, error)
-	GetAll(userID uuid.UUID) ([]models.Book, error)
-	GetByID(id uint) (models.Book, error)
-	Update(id uint, book models.Book) error
-	Delete(id uint) error
+	Create(ctx context.Context, book models.Book) (uint, error)
+	GetAll(ctx context.Context, userID uuid.UUID) ([]models.Book, error)
+	// GetByID returns ErrBookNotFound if there is no such book.
+	GetByID(ctx context.Context, id uint) (models.Book, error)
+	Update(ctx context.Context, id uint, book models.Book) error
+	Delete(ctx context.Context, id uint) error
+	CountByStatus(ctx context.Context, userID uuid.UUID) (map[string]int64, error)
+	ListByStatus(ctx context.Context, userID uuid.UUID, status string, limit int) ([]models.Book, error)
+	// ListRecent includes deleted books, most recently changed first.
+	ListRecent(ctx context.Context, userID uuid.UUID, limit int) ([]models.Book, error)
+	// ListChanges includes deleted books and orders by change time
+	// (COALESCE(deleted_at, updated_at)) and id, starting after (since, afterID).
+	ListChanges(ctx context.Context, userID uuid.UUID, since time.Time, afterID uint, limit int) ([]models.Book, error)
+	// GetForUpdate locks the book, deleted or not, until the transaction
+	// ends. It returns ErrBookNotFound if there is no such row.
+	GetForUpdate(ctx context.Context, id uint) (models.Book, error)
+	// Replace overwrites every user-editable field, zero values included.
+	Replace(ctx context.Context, book models.Book) (models.Book, error)
+	// Transaction runs fn with a repository bound to one transaction,
+	// committed when fn returns nil.
+	Transaction(ctx context.Context, fn func(repo BookRepository) error) error
 }
 
 type bookGorm struct {
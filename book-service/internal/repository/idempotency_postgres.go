@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"book-service/internal/models"
+	"context"
+	"errors"
+	"platform/idempotency"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reserveAttempts bounds how often Reserve retries when the key it
+// collided with is released before it can be read.
+const reserveAttempts = 3
+
+func (r *idempotencyGorm) Reserve(ctx context.Context, rec idempotency.Record, staleBefore time.Time) (idempotency.Record, bool, error) {
+	db := r.db.WithContext(ctx)
+	err := db.Where("scope = ? AND key = ?", rec.Scope, rec.Key).
+		Where("expires_at < ? OR (status = 0 AND created_at < ?)", time.Now(), staleBefore).
+		Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return rec, false, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		stored, reserved, err := r.reserve(db, rec)
+		// the first request was released between our insert and select
+		if errors.Is(err, gorm.ErrRecordNotFound) && attempt < reserveAttempts {
+			continue
+		}
+		return stored, reserved, err
+	}
+}
+
+func (r *idempotencyGorm) reserve(db *gorm.DB, rec idempotency.Record) (idempotency.Record, bool, error) {
+	row := models.IdempotencyKey(rec)
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error != nil || result.RowsAffected == 1 {
+		return rec, result.Error == nil, result.Error
+	}
+	var existing models.IdempotencyKey
+	err := db.Where("scope = ? AND key = ?", rec.Scope, rec.Key).First(&existing).Error
+	return idempotency.Record(existing), false, err
+}
+
+func (r *idempotencyGorm) Complete(ctx context.Context, scope, key string, status int, contentType string, body []byte) error {
+	return r.db.WithContext(ctx).Model(&models.IdempotencyKey{}).
+		Where("scope = ? AND key = ?", scope, key).
+		Updates(map[string]any{"status": status, "content_type": contentType, "body": body}).Error
+}
+
+func (r *idempotencyGorm) Release(ctx context.Context, scope, key string) error {
+	return r.db.WithContext(ctx).
+		Where("scope = ? AND key = ?", scope, key).
+		Delete(&models.IdempotencyKey{}).Error
+}
+
+func (r *idempotencyGorm) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&models.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"platform/idempotency"
+
+	"gorm.io/gorm"
+)
+
+type idempotencyGorm struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) idempotency.Store {
+	return &idempotencyGorm{db: db}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"book-service/internal/events"
+	"book-service/internal/models"
+	"book-service/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// BatchOutcome is the result of one batch operation: the book created,
+// updated or deleted (only its ID for a delete), or why it failed.
+type BatchOutcome struct {
+	Book models.Book
+	Err  error
+}
+
+// errBatchAborted rolls back an atomic batch after a failed operation.
+var errBatchAborted = errors.New("batch aborted")
+
+// ApplyBatch applies ops to userID's books in order. Atomic batches run in
+// one transaction that stops at the first failure, which is then the last
+// outcome; err reports a failure of the transaction itself. Otherwise
+// every operation applies on its own. Events go out once changes are
+// committed.
+func (s *BookService) ApplyBatch(ctx context.Context, userID uuid.UUID, atomic bool, ops []models.BatchOperation) ([]BatchOutcome, error) {
+	outcomes := make([]BatchOutcome, 0, len(ops))
+	if !atomic {
+		for _, op := range ops {
+			var o BatchOutcome
+			o.Err = s.repo.Transaction(ctx, func(tx repository.BookRepository) error {
+				o = applyBatchOp(ctx, tx, userID, op)
+				return o.Err
+			})
+			outcomes = append(outcomes, o)
+			if o.Err == nil {
+				s.publishOutcome(userID, op.Op, o)
+			}
+		}
+		return outcomes, nil
+	}
+
+	err := s.repo.Transaction(ctx, func(tx repository.BookRepository) error {
+		outcomes = outcomes[:0]
+		for _, op := range ops {
+			o := applyBatchOp(ctx, tx, userID, op)
+			outcomes = append(outcomes, o)
+			if o.Err != nil {
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchAborted) {
+		return outcomes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range outcomes {
+		s.publishOutcome(userID, ops[i].Op, o)
+	}
+	return outcomes, nil
+}
+
+func applyBatchOp(ctx context.Context, repo repository.BookRepository, userID uuid.UUID, op models.BatchOperation) BatchOutcome {
+	var o BatchOutcome
+	switch op.Op {
+	case models.OpCreate:
+		o.Book, o.Err = createBook(ctx, repo, userID, op.Book)
+	case models.OpUpdate:
+		o.Book, o.Err = updateBook(ctx, repo, userID, op.ID, op.Book)
+	case models.OpDelete:
+		o.Book.ID, o.Err = op.ID, deleteBook(ctx, repo, userID, op.ID)
+	default:
+		o.Err = fmt.Errorf("%w: op must be create, update or delete", ErrInvalidBook)
+	}
+	return o
+}
+
+func (s *BookService) publishOutcome(userID uuid.UUID, op string, o BatchOutcome) {
+	switch op {
+	case models.OpCreate:
+		s.publish(userID, events.BookCreated, o.Book)
+	case models.OpUpdate:
+		s.publish(userID, events.BookUpdated, o.Book)
+	case models.OpDelete:
+		s.publish(userID, events.BookDeleted, map[string]uint{"id": o.Book.ID})
+	}
+}
@@ -1,36 +1,204 @@
 package services
 
 import (
+	"book-service/internal/events"
 	"book-service/internal/models"
 	"book-service/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrInvalidStatus = errors.New("status must be want_to_read, reading or finished")
+	ErrInvalidBook   = errors.New("invalid book")
+	ErrBookNotFound  = repository.ErrBookNotFound
+)
+
 type BookService struct {
-	repo repository.BookRepository
+	repo   repository.BookRepository
+	events *events.Broker
+}
+
+func NewBookService(repo repository.BookRepository, broker *events.Broker) *BookService {
+	return &BookService{repo: repo, events: broker}
+}
+
+func (s *BookService) CreateBook(ctx context.Context, book models.Book) (uint, error) {
+	created, err := createBook(ctx, s.repo, book.UserID, book)
+	if err != nil {
+		return 0, err
+	}
+	s.publish(created.UserID, events.BookCreated, created)
+	return created.ID, nil
+}
+
+func (s *BookService) GetBooks(ctx context.Context, userID uuid.UUID) ([]models.Book, error) {
+	return s.repo.GetAll(ctx, userID)
+}
+
+// GetBook returns book id if userID owns it, ErrBookNotFound otherwise.
+func (s *BookService) GetBook(ctx context.Context, userID uuid.UUID, id uint) (models.Book, error) {
+	book, err := s.repo.GetByID(ctx, id)
+	if err == nil && book.UserID != userID {
+		return models.Book{}, ErrBookNotFound
+	}
+	return book, err
+}
+
+// UpdateBook changes the fields of userID's book id that are set in book.
+func (s *BookService) UpdateBook(ctx context.Context, userID uuid.UUID, id uint, book models.Book) error {
+	var updated models.Book
+	err := s.repo.Transaction(ctx, func(tx repository.BookRepository) error {
+		var err error
+		updated, err = updateBook(ctx, tx, userID, id, book)
+		return err
+	})
+	if err == nil {
+		s.publish(userID, events.BookUpdated, updated)
+	}
+	return err
 }
 
-func NewBookService(repo repository.BookRepository) *BookService {
-	return &BookService{repo}
+// DeleteBook deletes userID's book id.
+func (s *BookService) DeleteBook(ctx context.Context, userID uuid.UUID, id uint) error {
+	err := s.repo.Transaction(ctx, func(tx repository.BookRepository) error {
+		return deleteBook(ctx, tx, userID, id)
+	})
+	if err == nil {
+		s.publish(userID, events.BookDeleted, map[string]uint{"id": id})
+	}
+	return err
 }
 
-func (s *BookService) CreateBook(book models.Book) (uint, error) {
-	return s.repo.Create(book)
+// createBook, updateBook and deleteBook hold the rules shared by the
+// single-book endpoints and batches; repo may be bound to a transaction.
+// Books of other users are reported as not found.
+func createBook(ctx context.Context, repo repository.BookRepository, userID uuid.UUID, book models.Book) (models.Book, error) {
+	book.ID, book.UserID = 0, userID
+	if err := validateBook(&book); err != nil {
+		return book, err
+	}
+	id, err := repo.Create(ctx, book)
+	if err != nil {
+		return book, err
+	}
+	return repo.GetByID(ctx, id)
 }
 
-func (s *BookService) GetBooks(userID uuid.UUID) ([]models.Book, error) {
-	return s.repo.GetAll(userID)
+func updateBook(ctx context.Context, repo repository.BookRepository, userID uuid.UUID, id uint, book models.Book) (models.Book, error) {
+	if err := validateBookUpdate(&book); err != nil {
+		return book, err
+	}
+	if _, err := ownedForUpdate(ctx, repo, userID, id); err != nil {
+		return book, err
+	}
+	book.ID, book.UserID = 0, uuid.Nil
+	if err := repo.Update(ctx, id, book); err != nil {
+		return book, err
+	}
+	return repo.GetByID(ctx, id)
 }
 
-func (s *BookService) GetBook(id uint) (models.Book, error) {
-	return s.repo.GetByID(id)
+func deleteBook(ctx context.Context, repo repository.BookRepository, userID uuid.UUID, id uint) error {
+	if _, err := ownedForUpdate(ctx, repo, userID, id); err != nil {
+		return err
+	}
+	return repo.Delete(ctx, id)
+}
+
+// ownedForUpdate locks book id, which must exist and belong to userID.
+func ownedForUpdate(ctx context.Context, repo repository.BookRepository, userID uuid.UUID, id uint) (models.Book, error) {
+	book, err := repo.GetForUpdate(ctx, id)
+	if err == nil && (book.UserID != userID || book.DeletedAt.Valid) {
+		return models.Book{}, ErrBookNotFound
+	}
+	return book, err
+}
+
+// Subscribe streams changes to the user's books; see events.Broker.
+func (s *BookService) Subscribe(userID uuid.UUID, lastEventID string) (*events.Subscription, []events.Event, bool) {
+	return s.events.Subscribe(userID, lastEventID)
+}
+
+func (s *BookService) publish(userID uuid.UUID, typ string, data any) {
+	if err := s.events.Publish(userID, typ, data); err != nil {
+		log.Printf("failed to publish %s event: %v", typ, err)
+	}
+}
+
+// GetSummary counts the user's books per status and lists up to limit
+// books being read and recent changes.
+func (s *BookService) GetSummary(ctx context.Context, userID uuid.UUID, limit int) (models.BookSummary, error) {
+	summary := models.BookSummary{
+		ByStatus:         map[string]int64{models.StatusWantToRead: 0, models.StatusReading: 0, models.StatusFinished: 0},
+		CurrentlyReading: []models.Book{},
+		RecentActivity:   []models.BookActivity{},
+	}
+
+	counts, err := s.repo.CountByStatus(ctx, userID)
+	if err != nil {
+		return summary, err
+	}
+	for status, n := range counts {
+		summary.ByStatus[status] = n
+		summary.Total += n
+	}
+
+	reading, err := s.repo.ListByStatus(ctx, userID, models.StatusReading, limit)
+	if err != nil {
+		return summary, err
+	}
+	summary.CurrentlyReading = append(summary.CurrentlyReading, reading...)
+
+	recent, err := s.repo.ListRecent(ctx, userID, limit)
+	if err != nil {
+		return summary, err
+	}
+	for _, b := range recent {
+		activity := models.BookActivity{BookID: b.ID, Title: b.Title, Action: "updated", At: b.UpdatedAt}
+		switch {
+		case b.DeletedAt.Valid:
+			activity.Action, activity.At = "removed", b.DeletedAt.Time
+		case b.UpdatedAt.Sub(b.CreatedAt) < time.Second:
+			activity.Action = "added"
+		}
+		summary.RecentActivity = append(summary.RecentActivity, activity)
+	}
+	return summary, nil
 }
 
-func (s *BookService) UpdateBook(id uint, book models.Book) error {
-	return s.repo.Update(id, book)
+// validateBook checks the user-editable fields of a new book, defaulting
+// an empty status to want_to_read.
+func validateBook(b *models.Book) error {
+	b.Title, b.Author = strings.TrimSpace(b.Title), strings.TrimSpace(b.Author)
+	switch {
+	case b.Title == "":
+		return fmt.Errorf("%w: title is required", ErrInvalidBook)
+	case b.Author == "":
+		return fmt.Errorf("%w: author is required", ErrInvalidBook)
+	}
+	if b.Status == "" {
+		b.Status = models.StatusWantToRead
+	}
+	return validateBookUpdate(b)
 }
 
-func (s *BookService) DeleteBook(id uint) error {
-	return s.repo.Delete(id)
+// validateBookUpdate checks the fields an update sets; like the update
+// itself, it ignores zero values.
+func validateBookUpdate(b *models.Book) error {
+	switch {
+	case len(b.Title) > 255 || len(b.Author) > 255:
+		return fmt.Errorf("%w: title and author are limited to 255 characters", ErrInvalidBook)
+	case b.Year < 0 || b.Year > time.Now().Year()+1:
+		return fmt.Errorf("%w: year is out of range", ErrInvalidBook)
+	case b.Status != "" && !models.ValidStatus(b.Status):
+		return fmt.Errorf("%w: %v", ErrInvalidBook, ErrInvalidStatus)
+	}
+	return nil
 }
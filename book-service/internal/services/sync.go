@@ -0,0 +1,218 @@
+package services
+
+import (
+	"book-service/internal/events"
+	"book-service/internal/models"
+	"book-service/internal/repository"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// syncSkew covers writes that commit after a sync read them as not yet
+// there although their timestamps are older: tokens never point closer
+// than this to the present, so such changes come with the next sync.
+// Clients may therefore see a change twice and must apply them
+// idempotently.
+const syncSkew = 5 * time.Second
+
+// Changes lists the user's books changed since token (from scratch when
+// empty), oldest change first, at most limit of them. Deleted books come
+// as tombstones, except on a sync from scratch. Paging stops at the
+// syncSkew floor: a page that reaches into it reports no more, and the
+// rest comes with the next sync.
+func (s *BookService) Changes(ctx context.Context, userID uuid.UUID, token string, limit int) (models.SyncChanges, error) {
+	out := models.SyncChanges{Changes: []models.Book{}, Deleted: []models.BookTombstone{}}
+	since, afterID, err := decodeSyncToken(token)
+	if err != nil {
+		return out, err
+	}
+
+	start := time.Now()
+	books, err := s.repo.ListChanges(ctx, userID, since, afterID, limit+1)
+	if err != nil {
+		return out, err
+	}
+	if len(books) > limit {
+		books, out.HasMore = books[:limit], true
+	}
+
+	next, nextID := since, afterID
+	for _, b := range books {
+		if b.DeletedAt.Valid {
+			if token != "" {
+				out.Deleted = append(out.Deleted, models.BookTombstone{ID: b.ID, DeletedAt: b.DeletedAt.Time})
+			}
+		} else {
+			out.Changes = append(out.Changes, b)
+		}
+		next, nextID = changedAt(b), b.ID
+	}
+	if floor := start.Add(-syncSkew); next.After(floor) {
+		// paging on from the last row would skip late commits before it;
+		// paging from the floor would return this page again
+		next, nextID, out.HasMore = floor, 0, false
+	}
+	out.NextToken = encodeSyncToken(next, nextID)
+	return out, nil
+}
+
+func changedAt(b models.Book) time.Time {
+	if b.DeletedAt.Valid {
+		return b.DeletedAt.Time
+	}
+	return b.UpdatedAt
+}
+
+// Sync tokens are the position (change time, id) in the order of
+// ListChanges, opaque to clients.
+func encodeSyncToken(t time.Time, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", t.UnixNano(), id)))
+}
+
+func decodeSyncToken(token string) (time.Time, uint, error) {
+	if token == "" {
+		return time.Time{}, 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidSyncToken
+	}
+	nanos, id, ok := strings.Cut(string(raw), ".")
+	n, err1 := strconv.ParseInt(nanos, 10, 64)
+	i, err2 := strconv.ParseUint(id, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		return time.Time{}, 0, ErrInvalidSyncToken
+	}
+	return time.Unix(0, n), uint(i), nil
+}
+
+// ApplySync applies a client's offline mutations one by one and reports
+// each outcome. An update or delete whose BaseUpdatedAt still matches the
+// server copy always applies. Otherwise the book changed on the server
+// since the client saw it: with SyncReject the mutation is refused, with
+// SyncLastWriterWins it applies only if ModifiedAt is later than the
+// server's UpdatedAt. Books deleted on the server stay deleted.
+func (s *BookService) ApplySync(ctx context.Context, userID uuid.UUID, strategy string, mutations []models.SyncMutation) []models.SyncResult {
+	results := make([]models.SyncResult, len(mutations))
+	for i, m := range mutations {
+		res, err := s.applyMutation(ctx, userID, strategy, m)
+		if err != nil {
+			log.Printf("sync mutation %d (%s %d) failed: %v", i, m.Op, m.ID, err)
+			res = models.SyncResult{ClientID: m.ClientID, ID: m.ID, Status: models.SyncError, Error: "internal error"}
+		}
+		results[i] = res
+	}
+	return results
+}
+
+func (s *BookService) applyMutation(ctx context.Context, userID uuid.UUID, strategy string, m models.SyncMutation) (models.SyncResult, error) {
+	res := models.SyncResult{ClientID: m.ClientID, ID: m.ID}
+	invalid := func(msg string) (models.SyncResult, error) {
+		res.Status, res.Error = models.SyncInvalid, msg
+		return res, nil
+	}
+
+	switch m.Op {
+	case models.OpCreate:
+		created, err := createBook(ctx, s.repo, userID, m.Book)
+		if errors.Is(err, ErrInvalidBook) {
+			return invalid(err.Error())
+		}
+		if err != nil {
+			return res, err
+		}
+		s.publish(userID, events.BookCreated, created)
+		res.ID, res.Status, res.Book = created.ID, models.SyncApplied, &created
+		return res, nil
+	case models.OpUpdate, models.OpDelete:
+	default:
+		return invalid("op must be create, update or delete")
+	}
+
+	if m.ID == 0 {
+		return invalid("id is required")
+	}
+	if strategy == models.SyncReject && m.BaseUpdatedAt == nil {
+		return invalid("base_updated_at is required")
+	}
+	if strategy == models.SyncLastWriterWins && m.ModifiedAt.IsZero() {
+		return invalid("modified_at is required")
+	}
+	if m.Op == models.OpUpdate {
+		if err := validateBook(&m.Book); err != nil {
+			return invalid(err.Error())
+		}
+	}
+
+	event := ""
+	err := s.repo.Transaction(ctx, func(tx repository.BookRepository) error {
+		current, err := tx.GetForUpdate(ctx, m.ID)
+		if errors.Is(err, repository.ErrBookNotFound) || (err == nil && current.UserID != userID) {
+			res.Status = models.SyncNotFound
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		res.Book, res.Deleted = &current, current.DeletedAt.Valid
+		switch {
+		case current.DeletedAt.Valid && m.Op == models.OpDelete:
+			res.Status = models.SyncApplied
+			return nil
+		case current.DeletedAt.Valid:
+			res.Status = models.SyncConflict
+			return nil
+		case !syncWins(strategy, m, current):
+			res.Status = models.SyncConflict
+			return nil
+		}
+
+		if m.Op == models.OpDelete {
+			if err := tx.Delete(ctx, m.ID); err != nil {
+				return err
+			}
+			if current, err = tx.GetForUpdate(ctx, m.ID); err != nil {
+				return err
+			}
+			res.Book, res.Deleted, event = &current, true, events.BookDeleted
+		} else {
+			current.Title, current.Author, current.Description = m.Book.Title, m.Book.Author, m.Book.Description
+			current.Year, current.Status = m.Book.Year, m.Book.Status
+			if current, err = tx.Replace(ctx, current); err != nil {
+				return err
+			}
+			res.Book, event = &current, events.BookUpdated
+		}
+		res.Status = models.SyncApplied
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	switch event {
+	case events.BookDeleted:
+		s.publish(userID, event, map[string]uint{"id": m.ID})
+	case events.BookUpdated:
+		s.publish(userID, event, *res.Book)
+	}
+	return res, nil
+}
+
+func syncWins(strategy string, m models.SyncMutation, current models.Book) bool {
+	if m.BaseUpdatedAt != nil && m.BaseUpdatedAt.Equal(current.UpdatedAt) {
+		return true
+	}
+	return strategy == models.SyncLastWriterWins && m.ModifiedAt.After(current.UpdatedAt)
+}
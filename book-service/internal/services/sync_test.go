@@ -0,0 +1,103 @@
+package services
+
+import (
+	"book-service/internal/models"
+	"book-service/internal/repository"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// changesRepo serves ListChanges from memory; the sync tests need nothing
+// else of the repository.
+type changesRepo struct {
+	repository.BookRepository
+	books []models.Book
+}
+
+func (r *changesRepo) ListChanges(_ context.Context, userID uuid.UUID, since time.Time, afterID uint, limit int) ([]models.Book, error) {
+	var out []models.Book
+	for _, b := range r.books {
+		t := changedAt(b)
+		if b.UserID == userID && (t.After(since) || t.Equal(since) && b.ID > afterID) {
+			out = append(out, b)
+		}
+	}
+	slices.SortFunc(out, func(a, b models.Book) int {
+		if c := changedAt(a).Compare(changedAt(b)); c != 0 {
+			return c
+		}
+		return int(a.ID) - int(b.ID)
+	})
+	return out[:min(limit, len(out))], nil
+}
+
+func TestChangesPaging(t *testing.T) {
+	user := uuid.New()
+	now := time.Now()
+	book := func(id uint, age time.Duration) models.Book {
+		return models.Book{ID: id, UserID: user, Title: "t", UpdatedAt: now.Add(-age)}
+	}
+
+	tests := []struct {
+		name  string
+		books []models.Book
+		late  []models.Book // committed after the first page was read
+		pages [][]uint      // ids per call, until HasMore is false
+		then  []uint        // ids of the next sync
+	}{
+		{"pages through settled changes",
+			[]models.Book{book(1, time.Hour), book(2, 50*time.Minute), book(3, 40*time.Minute)}, nil,
+			[][]uint{{1, 2}, {3}}, []uint{}},
+		{"late commit between pages inside the skew window",
+			[]models.Book{book(1, time.Hour), book(2, 2*time.Second), book(3, time.Second)},
+			[]models.Book{book(4, 3*time.Second)},
+			[][]uint{{1, 2}}, []uint{4, 2, 3}},
+		{"late commit after a settled page",
+			[]models.Book{book(1, time.Hour), book(2, 50*time.Minute), book(3, time.Second)},
+			[]models.Book{book(4, 2*time.Second)},
+			[][]uint{{1, 2}, {4, 3}}, []uint{4, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &changesRepo{books: slices.Clone(tt.books)}
+			s := NewBookService(repo, nil)
+
+			ids := func(out models.SyncChanges) []uint {
+				got := []uint{}
+				for _, b := range out.Changes {
+					got = append(got, b.ID)
+				}
+				return got
+			}
+
+			token := ""
+			for i, want := range tt.pages {
+				out, err := s.Changes(context.Background(), user, token, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := ids(out); !slices.Equal(got, want) {
+					t.Fatalf("page %d: ids %v, want %v", i, got, want)
+				}
+				if wantMore := i < len(tt.pages)-1; out.HasMore != wantMore {
+					t.Fatalf("page %d: has_more %v, want %v", i, out.HasMore, wantMore)
+				}
+				token = out.NextToken
+				repo.books = append(repo.books, tt.late...)
+				tt.late = nil
+			}
+
+			out, err := s.Changes(context.Background(), user, token, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(out); !slices.Equal(got, tt.then) {
+				t.Errorf("next sync: ids %v, want %v", got, tt.then)
+			}
+		})
+	}
+}
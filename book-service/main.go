@@ -4,51 +4,150 @@ import (
 	"book-service/config"
 	"book-service/database"
 	"book-service/handlers"
+	"book-service/internal/events"
 	"book-service/internal/models"
 	"book-service/internal/repository"
 	"book-service/internal/services"
 	"book-service/middleware"
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"platform/health"
+	"platform/idempotency"
+	"platform/logging"
+	"platform/metrics"
+	"platform/request"
+	"platform/tracing"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const serviceName = "book-service"
+
 func main() {
+	if err := logging.Setup(serviceName); err != nil {
+		log.Fatalf("failed to set up logging: %v", err)
+	}
 	cfg := config.Load()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName)
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	db := database.Connect(cfg)
 
-	if err := db.AutoMigrate(&models.Book{}); err != nil {
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("failed to get DB handle: %v", err)
+	}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(sqlDB, "bookdb"))
+
+	if err := db.AutoMigrate(&models.Book{}, &models.IdempotencyKey{}); err != nil {
 		log.Fatal("failed to migrate DB:", err)
 	}
 	log.Println("✅ Book service DB migrated successfully")
 
+	broker := events.NewBroker(events.Config{
+		ReplaySize:       cfg.StreamReplaySize,
+		ReplayTTL:        cfg.StreamReplayTTL,
+		SubscriberBuffer: 64,
+	})
+
 	bookRepo := repository.NewBookRepository(db)
-	bookService := services.NewBookService(bookRepo)
-	bookHandler := handlers.NewBookHandler(bookService)
+	bookService := services.NewBookService(bookRepo, broker)
+	bookHandler := handlers.NewBookHandler(bookService, cfg.StreamHeartbeat)
+	probes := health.NewHandler(sqlDB)
+	idempotencyKeys := idempotency.NewHandler(repository.NewIdempotencyRepository(db), cfg.IdempotencyTTL, cfg.JWTSecret)
 
-	r := gin.Default()
+	r := gin.New()
+	r.SetTrustedProxies(nil)
+	r.Use(request.IDMiddleware(), logging.Middleware(), tracing.Middleware(serviceName), metrics.Middleware())
+	// a panicking handler becomes a 500 that the middleware above still records
+	r.Use(logging.Recovery())
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/livez", probes.Livez)
+	r.GET("/readyz", probes.Readyz)
 	r.GET("/public", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Hello World",
 		})
 	})
 
+	var authMiddleware gin.HandlerFunc
+	switch cfg.AuthMode {
+	case "jwt":
+		authMiddleware = middleware.AuthMiddleware(cfg.JWTSecret)
+	case "gateway":
+		if cfg.IdentitySecret == "" {
+			log.Println("⚠️ AUTH_MODE=gateway without GATEWAY_IDENTITY_SECRET: identity headers are trusted unsigned")
+		}
+		authMiddleware = middleware.GatewayIdentityMiddleware(cfg.IdentitySecret)
+	default:
+		log.Fatalf("unknown AUTH_MODE %q", cfg.AuthMode)
+	}
+
 	auth := r.Group("/")
 
-	auth.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	auth.Use(authMiddleware, middleware.ScopeMiddleware(), idempotencyKeys.Middleware())
 	{
 		auth.POST("/books", bookHandler.CreateBook)
+		auth.POST("/books/batch", bookHandler.BatchBooks)
 		auth.PUT("/books/:id", bookHandler.UpdateBook)
 		auth.DELETE("/books/:id", bookHandler.DeleteBook)
 		auth.GET("/books", bookHandler.GetBooks)
+		auth.GET("/books/summary", bookHandler.GetSummary)
+		auth.GET("/books/stream", bookHandler.StreamBooks)
+		auth.GET("/sync", bookHandler.GetSync)
+		auth.POST("/sync", bookHandler.PostSync)
 		auth.GET("/books/:id", bookHandler.GetBook)
 	}
 
-	log.Printf("Book service running on %s", cfg.ServerAddress)
-	if err := r.Run(cfg.ServerAddress); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	// user-service pulls a user's books from here for data exports
+	internal := r.Group("/internal")
+	internal.Use(middleware.ServiceAuthMiddleware(cfg.ServiceToken))
+	{
+		internal.GET("/users/:userID/books", bookHandler.ExportUserBooks)
 	}
 
-	r.Run(":8081")
+	srv := &http.Server{Addr: cfg.ServerAddress, Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	// open change streams would otherwise hold Shutdown for its whole timeout
+	srv.RegisterOnShutdown(broker.Close)
+	go func() {
+		log.Printf("Book service running on %s", cfg.ServerAddress)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// On SIGTERM fail readiness and let requests in flight finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go broker.RunCleanup(ctx, time.Minute)
+	go idempotencyKeys.RunCleanup(ctx, 10*time.Minute)
+	<-ctx.Done()
+	log.Printf("shutting down: readiness off for %s, then draining for up to %s", cfg.PreStopDelay, cfg.ShutdownTimeout)
+	probes.Drain()
+	// new requests keep arriving until the load balancer sees /readyz fail
+	time.Sleep(cfg.PreStopDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		log.Printf("closing DB: %v", err)
+	}
+	log.Println("Book service stopped")
 }
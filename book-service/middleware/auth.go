@@ -42,7 +42,39 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 		claims := token.Claims.(jwt.MapClaims)
 		c.Set("userID", claims["sub"])
 		c.Set("role", claims["role"])
+		// only tokens exchanged for a personal access token carry a scope
+		if scope, ok := claims["scope"].(string); ok && scope != "" {
+			c.Set("scopes", strings.Fields(scope))
+		}
 
 		c.Next()
 	}
 }
+
+// ScopeMiddleware enforces personal access token scopes: reads need
+// books:read or books:write, anything else needs books:write. Requests with
+// an unscoped (regular login) token pass through untouched.
+func ScopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, exists := c.Get("scopes")
+		if !exists {
+			c.Next()
+			return
+		}
+		scopes := v.([]string)
+
+		required := "books:write"
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			required = "books:read"
+		}
+		for _, s := range scopes {
+			if s == required || s == "books:write" {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "token scope does not allow this operation"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testIdentitySecret = "s3cret"
+
+// identityRequest builds the request the gateway would send, signed at ts.
+func identityRequest(userID, role, scopes string, ts time.Time) *http.Request {
+	const clientIP = "192.0.2.1"
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testIdentitySecret))
+	mac.Write([]byte(strings.Join([]string{userID, role, scopes, clientIP, unix}, "\n")))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User-ID", userID)
+	req.Header.Set("X-User-Role", role)
+	if scopes != "" {
+		req.Header.Set("X-User-Scopes", scopes)
+	}
+	req.Header.Set("X-Client-IP", clientIP)
+	req.Header.Set("X-Identity-Timestamp", unix)
+	req.Header.Set("X-Identity-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestGatewayIdentityMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		secret     string
+		req        func() *http.Request
+		want       int
+		wantScopes string
+	}{
+		{"valid", testIdentitySecret, func() *http.Request {
+			return identityRequest("u1", "user", "", now)
+		}, http.StatusOK, ""},
+		{"scoped token", testIdentitySecret, func() *http.Request {
+			return identityRequest("u1", "user", "books:read", now)
+		}, http.StatusOK, "books:read"},
+		{"widened scopes", testIdentitySecret, func() *http.Request {
+			r := identityRequest("u1", "user", "books:read", now)
+			r.Header.Set("X-User-Scopes", "books:read books:write")
+			return r
+		}, http.StatusUnauthorized, ""},
+		{"dropped scopes", testIdentitySecret, func() *http.Request {
+			r := identityRequest("u1", "user", "books:read", now)
+			r.Header.Del("X-User-Scopes")
+			return r
+		}, http.StatusUnauthorized, ""},
+		{"tampered user", testIdentitySecret, func() *http.Request {
+			r := identityRequest("u1", "user", "", now)
+			r.Header.Set("X-User-ID", "u2")
+			return r
+		}, http.StatusUnauthorized, ""},
+		{"tampered client ip", testIdentitySecret, func() *http.Request {
+			r := identityRequest("u1", "user", "", now)
+			r.Header.Set("X-Client-IP", "203.0.113.9")
+			return r
+		}, http.StatusUnauthorized, ""},
+		{"expired", testIdentitySecret, func() *http.Request {
+			return identityRequest("u1", "user", "", now.Add(-2*identityMaxSkew))
+		}, http.StatusUnauthorized, ""},
+		{"from the future", testIdentitySecret, func() *http.Request {
+			return identityRequest("u1", "user", "", now.Add(2*identityMaxSkew))
+		}, http.StatusUnauthorized, ""},
+		{"bad timestamp", testIdentitySecret, func() *http.Request {
+			r := identityRequest("u1", "user", "", now)
+			r.Header.Set("X-Identity-Timestamp", "yesterday")
+			return r
+		}, http.StatusUnauthorized, ""},
+		{"no user", testIdentitySecret, func() *http.Request {
+			r := identityRequest("u1", "user", "", now)
+			r.Header.Del("X-User-ID")
+			return r
+		}, http.StatusUnauthorized, ""},
+		{"no secret configured", "", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User-ID", "u1")
+			return r
+		}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(GatewayIdentityMiddleware(tt.secret))
+			r.GET("/", func(c *gin.Context) {
+				c.String(http.StatusOK, "%s|%s", c.GetString("userID"), strings.Join(c.GetStringSlice("scopes"), " "))
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req())
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d (%s)", w.Code, tt.want, w.Body)
+			}
+			if want := "u1|" + tt.wantScopes; w.Code == http.StatusOK && w.Body.String() != want {
+				t.Errorf("identity %q, want %q", w.Body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceAuthMiddleware guards internal endpoints that are only meant to be
+// called by other BookLog services. Callers authenticate with the shared
+// X-Service-Token header; end-user JWTs are not accepted here.
+func ServiceAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "service authentication not configured"})
+			return
+		}
+
+		got := c.GetHeader("X-Service-Token")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid service token"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"platform/logging"
+	"platform/request"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewAdminServer builds the admin API. It is served on its own listener
+// (GATEWAY_ADMIN_ADDR) so that it can be kept off the public network, and
+// every endpoint requires the admin token. Actions apply to the active
+// config; drains are remembered across reloads, breaker resets and cache
+// flushes only affect the current route set.
+func NewAdminServer(configs *ConfigManager, shared *Shared, token string) *gin.Engine {
+	r := gin.New()
+	r.SetTrustedProxies(nil)
+	r.Use(request.IDMiddleware(), logging.Middleware(), logging.Recovery())
+
+	admin := r.Group("/", AdminAuthMiddleware(token))
+	admin.GET("/config", func(c *gin.Context) { c.JSON(http.StatusOK, configs.Status()) })
+	admin.POST("/config/reload", func(c *gin.Context) {
+		changed, err := configs.Reload()
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "config": configs.Status()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"changed": changed, "config": configs.Status()})
+	})
+
+	admin.GET("/routes", func(c *gin.Context) { c.JSON(http.StatusOK, routeViews(configs.Config().cfg)) })
+
+	admin.GET("/upstreams", func(c *gin.Context) {
+		rt := configs.Config().router
+		names := make([]string, 0, len(rt.upstreams))
+		for name := range rt.upstreams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		views := make([]upstreamView, 0, len(names))
+		for _, name := range names {
+			views = append(views, newUpstreamView(rt.upstreams[name]))
+		}
+		c.JSON(http.StatusOK, views)
+	})
+	admin.POST("/upstreams/:name/drain", drainHandler(configs, shared, true))
+	admin.POST("/upstreams/:name/undrain", drainHandler(configs, shared, false))
+	admin.POST("/upstreams/:name/breaker/reset", func(c *gin.Context) {
+		u, ok := configs.Config().router.upstreams[c.Param("name")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown upstream"})
+			return
+		}
+		u.breaker.Reset()
+		c.JSON(http.StatusOK, newUpstreamView(u))
+	})
+
+	admin.GET("/ratelimits", func(c *gin.Context) {
+		body := gin.H{"routes": shared.RateLimitStats.Snapshot()}
+		if store, ok := shared.RateLimits.(interface{ Len() int }); ok {
+			body["buckets"] = store.Len()
+		}
+		c.JSON(http.StatusOK, body)
+	})
+
+	admin.GET("/streams", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"open": shared.Streams.Snapshot()}) })
+
+	admin.GET("/cache", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"responses":     shared.Responses.Stats(),
+			"introspection": gin.H{"entries": shared.Tokens.Len()},
+		})
+	})
+	// DELETE /cache flushes every cached response, or with ?route= (and
+	// optionally &path= and &user=) only those of one route at or below
+	// path, for every user or for one user and the shared entries.
+	admin.DELETE("/cache", func(c *gin.Context) {
+		cache := shared.Responses
+		route := c.Query("route")
+		if route == "" {
+			n := cache.Len()
+			cache.Purge()
+			c.JSON(http.StatusOK, gin.H{"removed": n})
+			return
+		}
+		path := c.DefaultQuery("path", "/")
+		if user := c.Query("user"); user != "" {
+			c.JSON(http.StatusOK, gin.H{"removed": cache.Invalidate(route, user, path)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"removed": cache.InvalidateAllUsers(route, path)})
+	})
+	admin.DELETE("/cache/introspection", func(c *gin.Context) {
+		tokens := shared.Tokens
+		n := tokens.Len()
+		tokens.Purge()
+		c.JSON(http.StatusOK, gin.H{"removed": n})
+	})
+	return r
+}
+
+// drainHandler takes one target (?target=URL) or, without one, every
+// target of an upstream out of rotation, or puts it back.
+func drainHandler(configs *ConfigManager, shared *Shared, drain bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := configs.Config().router.upstreams[c.Param("name")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown upstream"})
+			return
+		}
+		targets := u.targets
+		if raw := c.Query("target"); raw != "" {
+			t := u.Target(raw)
+			if t == nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "unknown target"})
+				return
+			}
+			targets = []*Target{t}
+		}
+		for _, t := range targets {
+			shared.Drains.Set(u.name, t, drain)
+		}
+		c.JSON(http.StatusOK, newUpstreamView(u))
+	}
+}
+
+type upstreamView struct {
+	Name     string         `json:"name"`
+	Strategy string         `json:"strategy"`
+	Breaker  string         `json:"breaker"`
+	Targets  []TargetStatus `json:"targets"`
+}
+
+func newUpstreamView(u *Upstream) upstreamView {
+	v := upstreamView{Name: u.name, Strategy: u.cfg.Strategy, Breaker: u.breaker.State()}
+	if v.Strategy == "" {
+		v.Strategy = StrategyRoundRobin
+	}
+	for _, t := range u.targets {
+		v.Targets = append(v.Targets, t.Status())
+	}
+	return v
+}
+
+type routeView struct {
+	Name        string            `json:"name"`
+	PathPrefix  string            `json:"path_prefix"`
+	Upstream    string            `json:"upstream,omitempty"`
+	Compose     map[string]string `json:"compose,omitempty"` // part -> upstream:path
+	Methods     []string          `json:"methods,omitempty"`
+	Auth        bool              `json:"auth"`
+	Timeout     string            `json:"timeout,omitempty"`
+	Retries     int               `json:"retries"`
+	RateLimit   string            `json:"rate_limit,omitempty"` // "requests/period burst N"
+	CacheTTL    string            `json:"cache_ttl,omitempty"`
+	Streaming   *StreamConfig     `json:"streaming,omitempty"`
+	CORSOrigins []string          `json:"cors_origins,omitempty"`
+}
+
+func routeViews(cfg *GatewayConfig) []routeView {
+	views := make([]routeView, 0, len(cfg.Routes))
+	for _, rc := range cfg.Routes {
+		v := routeView{
+			Name:       rc.Name,
+			PathPrefix: rc.PathPrefix,
+			Upstream:   rc.Upstream,
+			Methods:    rc.Methods,
+			Auth:       rc.Auth && cfg.Auth.Enabled,
+			Timeout:    durationString(rc.Timeout),
+			Retries:    rc.Retries.Attempts,
+		}
+		if len(rc.Compose) > 0 {
+			v.Compose = make(map[string]string, len(rc.Compose))
+			for _, p := range rc.Compose {
+				v.Compose[p.Name] = p.Upstream + ":" + p.Path
+			}
+		}
+		if rl := rc.RateLimit; rl.Requests > 0 {
+			v.RateLimit = fmt.Sprintf("%d/%s burst %d", rl.Requests, rl.Period, rl.Burst)
+		}
+		if rc.Cache.Enabled {
+			v.CacheTTL = rc.Cache.TTL.String()
+		}
+		if rc.Streaming.Enabled {
+			st := rc.Streaming
+			v.Streaming = &st
+		}
+		if rc.CORS != nil {
+			v.CORSOrigins = rc.CORS.AllowedOrigins
+		}
+		views = append(views, v)
+	}
+	return views
+}
+
+func durationString(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitOpenError is returned while an upstream's breaker rejects requests.
+type CircuitOpenError struct {
+	Upstream   string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for upstream %s is open", e.Upstream)
+}
+
+type breakerResult int
+
+const (
+	breakerSuccess breakerResult = iota
+	breakerFailure
+	breakerIgnore // e.g. the client went away, which says nothing about the upstream
+)
+
+// CircuitBreaker fails fast while an upstream is down. After
+// FailureThreshold consecutive failures it opens for OpenTimeout, then lets
+// HalfOpenRequests trial requests through: if they all succeed it closes
+// again, any failure re-opens it.
+type CircuitBreaker struct {
+	name string
+	cfg  CircuitBreakerConfig
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	trials    int // half-open requests in flight or finished
+	successes int // half-open requests that succeeded
+}
+
+func NewCircuitBreaker(name string, cfg CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{name: name, cfg: cfg, state: BreakerClosed}
+}
+
+// Allow asks permission to send one request. Unless it returns an error,
+// the caller must report the outcome through done.
+func (b *CircuitBreaker) Allow() (done func(breakerResult), err error) {
+	if b.cfg.FailureThreshold <= 0 {
+		return func(breakerResult) {}, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if wait := b.cfg.OpenTimeout - time.Since(b.openedAt); wait > 0 {
+			return nil, &CircuitOpenError{Upstream: b.name, RetryAfter: wait}
+		}
+		b.state, b.trials, b.successes = BreakerHalfOpen, 0, 0
+	}
+
+	trial := b.state == BreakerHalfOpen
+	if trial {
+		if b.trials >= b.cfg.HalfOpenRequests {
+			return nil, &CircuitOpenError{Upstream: b.name, RetryAfter: time.Second}
+		}
+		b.trials++
+	}
+
+	return func(res breakerResult) { b.record(trial, res) }, nil
+}
+
+func (b *CircuitBreaker) record(trial bool, res breakerResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		if b.state != BreakerHalfOpen {
+			return // another trial already decided
+		}
+		switch res {
+		case breakerIgnore:
+			b.trials-- // give the slot to the next request
+		case breakerFailure:
+			b.trip()
+		case breakerSuccess:
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenRequests {
+				b.state, b.failures = BreakerClosed, 0
+				log.Printf("upstream %s: circuit breaker closed", b.name)
+			}
+		}
+		return
+	}
+
+	if b.state != BreakerClosed {
+		return
+	}
+	switch res {
+	case breakerSuccess:
+		b.failures = 0
+	case breakerFailure:
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.trip()
+		}
+	}
+}
+
+func (b *CircuitBreaker) trip() {
+	b.state, b.openedAt, b.failures = BreakerOpen, time.Now(), 0
+	log.Printf("upstream %s: circuit breaker open for %s", b.name, b.cfg.OpenTimeout)
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Reset forces the breaker closed.
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state, b.failures, b.trials, b.successes = BreakerClosed, 0, 0, 0
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPartBytes bounds each upstream response read by a composition.
+const maxPartBytes = 1 << 20
+
+// partError marks a part of a composed response that could not be fetched.
+type partError struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+type composePart struct {
+	cfg       ComposePartConfig
+	transport http.RoundTripper
+}
+
+// ComposeHandler serves a composition route: every part is requested
+// concurrently with the caller's identity and the JSON bodies are merged
+// into one object keyed by part name. A part that fails or times out is
+// null in the result and described under "errors", with "partial" set;
+// only when every part fails is the response a 502.
+func ComposeHandler(route RouteConfig, upstreams map[string]*Upstream, transport http.RoundTripper, identitySecret string) gin.HandlerFunc {
+	parts := make([]composePart, len(route.Compose))
+	for i, pc := range route.Compose {
+		parts[i] = composePart{
+			cfg:       pc,
+			transport: &upstreamTransport{upstream: upstreams[pc.Upstream], base: transport, retries: route.Retries},
+		}
+	}
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if route.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+			defer cancel()
+		}
+		key := c.GetString("userID")
+		if key == "" {
+			key = c.ClientIP()
+		}
+		ctx = context.WithValue(ctx, balanceKeyCtx{}, key)
+
+		header := http.Header{}
+		header.Set("Accept", "application/json")
+		header.Set("X-Request-ID", c.GetString("requestID"))
+		if auth := c.GetHeader("Authorization"); auth != "" {
+			header.Set("Authorization", auth)
+		}
+		setIdentityHeaders(c, header, identitySecret)
+
+		results := make([]json.RawMessage, len(parts))
+		errs := make([]*partError, len(parts))
+		var wg sync.WaitGroup
+		for i, p := range parts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], errs[i] = p.fetch(ctx, header)
+			}()
+		}
+		wg.Wait()
+
+		body := make(map[string]any, len(parts)+2)
+		failures := map[string]*partError{}
+		for i, p := range parts {
+			if errs[i] != nil {
+				body[p.cfg.Name] = nil
+				failures[p.cfg.Name] = errs[i]
+				continue
+			}
+			body[p.cfg.Name] = results[i]
+		}
+		status := http.StatusOK
+		if len(failures) > 0 {
+			body["partial"] = true
+			body["errors"] = failures
+			if len(failures) == len(parts) {
+				status = http.StatusBadGateway
+			}
+		}
+		c.AbortWithStatusJSON(status, body)
+	}
+}
+
+func (p composePart) fetch(ctx context.Context, header http.Header) (json.RawMessage, *partError) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	u, _ := url.Parse(p.cfg.Path) // checked by Validate
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "", nil)
+	if err != nil {
+		return nil, &partError{Status: http.StatusInternalServerError, Error: err.Error()}
+	}
+	req.URL = u
+	req.Header = header.Clone()
+
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		return nil, composeError(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxPartBytes+1))
+	if err != nil {
+		return nil, composeError(err)
+	}
+	if resp.StatusCode >= 300 {
+		var upstreamErr struct {
+			Error string `json:"error"`
+		}
+		msg := fmt.Sprintf("upstream returned %d", resp.StatusCode)
+		if json.Unmarshal(raw, &upstreamErr) == nil && upstreamErr.Error != "" {
+			msg = upstreamErr.Error
+		}
+		return nil, &partError{Status: resp.StatusCode, Error: msg}
+	}
+	if len(raw) > maxPartBytes || !json.Valid(raw) {
+		return nil, &partError{Status: http.StatusBadGateway, Error: "invalid upstream response"}
+	}
+	return raw, nil
+}
+
+// composeError maps a transport error like proxyErrorHandler does.
+func composeError(err error) *partError {
+	var openErr *CircuitOpenError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &openErr), errors.Is(err, errNoHealthyTarget):
+		return &partError{Status: http.StatusServiceUnavailable, Error: "upstream unavailable"}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &partError{Status: http.StatusGatewayTimeout, Error: "upstream timed out"}
+	}
+	return &partError{Status: http.StatusBadGateway, Error: "upstream request failed"}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultRouteTimeout = 30 * time.Second
+
+// GatewayConfig is the declarative gateway setup, loaded from YAML or JSON
+// (GATEWAY_CONFIG). Values may reference environment variables as ${VAR}
+// or ${VAR:-default}.
+type GatewayConfig struct {
+	Auth      AuthConfig                `yaml:"auth"`
+	Upstreams map[string]UpstreamConfig `yaml:"upstreams"`
+	Routes    []RouteConfig             `yaml:"routes"`
+
+	// defaults for routes without their own cors / security_headers
+	CORS            CORSConfig            `yaml:"cors"`
+	SecurityHeaders SecurityHeadersConfig `yaml:"security_headers"`
+
+	ResponseCache ResponseCacheConfig `yaml:"response_cache"`
+}
+
+// AuthConfig controls how JWTMiddleware verifies tokens.
+type AuthConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	Algo             string `yaml:"algo"` // HS256, RS256 or empty for auto
+	HSSecret         string `yaml:"hs_secret"`
+	RSPublicKey      string `yaml:"rs_public_key"` // PEM
+	IntrospectURL    string `yaml:"introspect_url"`
+	PATIntrospectURL string `yaml:"pat_introspect_url"`
+	ServiceToken     string `yaml:"service_token"`
+
+	// IdentitySecret signs the X-User-* headers forwarded to backends.
+	IdentitySecret string `yaml:"identity_secret"`
+
+	Cache IntrospectionCacheConfig `yaml:"cache"`
+}
+
+// IntrospectionCacheConfig bounds the cache of introspection results.
+// MaxTTL caps how long a revoked token can keep working.
+type IntrospectionCacheConfig struct {
+	MaxEntries  int           `yaml:"max_entries"`
+	MaxTTL      time.Duration `yaml:"max_ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+}
+
+// UpstreamConfig describes a pool of backend instances. URL is shorthand
+// for a single target.
+type UpstreamConfig struct {
+	URL         string            `yaml:"url"`
+	Targets     []string          `yaml:"targets"`
+	Strategy    string            `yaml:"strategy"` // round_robin (default), least_conn, consistent_hash
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	Ejection    EjectionConfig    `yaml:"ejection"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// HealthCheckConfig enables active probing of every target when Path is set.
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+}
+
+// EjectionConfig takes a target out of rotation for Duration after
+// MaxFailures consecutive 5xx responses or connection errors.
+type EjectionConfig struct {
+	MaxFailures int           `yaml:"max_failures"`
+	Duration    time.Duration `yaml:"duration"`
+}
+
+// CircuitBreakerConfig guards a whole upstream. A negative
+// failure_threshold disables the breaker.
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+	HalfOpenRequests int           `yaml:"half_open_requests"`
+}
+
+// RetryConfig retries idempotent requests after connection errors and
+// 502/503/504 responses. Attempts counts the first try, so 1 disables
+// retries. POST and PATCH are only retried with an Idempotency-Key, and
+// only where IdempotencyKey says the upstream deduplicates by it. Request
+// bodies up to MaxBodyBytes are buffered to be replayed; larger ones are
+// sent once.
+type RetryConfig struct {
+	Attempts       int           `yaml:"attempts"`
+	Backoff        time.Duration `yaml:"backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	MaxBodyBytes   int           `yaml:"max_body_bytes"`
+	IdempotencyKey bool          `yaml:"idempotency_key"`
+}
+
+// RateLimitConfig allows Requests per Period for each user (or client IP
+// when anonymous), with bursts of up to Burst. Zero requests disables it.
+type RateLimitConfig struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+}
+
+// ResponseCacheConfig bounds the in-memory store shared by all routes with
+// caching enabled. Responses larger than MaxObjectBytes are not stored.
+type ResponseCacheConfig struct {
+	MaxEntries     int `yaml:"max_entries"`
+	MaxBytes       int `yaml:"max_bytes"`
+	MaxObjectBytes int `yaml:"max_object_bytes"`
+}
+
+// RouteCacheConfig caches GET responses of a route. Upstream Cache-Control
+// and Expires decide freshness; TTL applies to responses that have
+// neither. Successful writes invalidate the collection they wrote to.
+type RouteCacheConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// StreamConfig lets a route carry WebSocket upgrades and Server-Sent
+// Events. Streams are exempt from the route timeout and instead closed
+// after IdleTimeout without traffic in either direction. MaxPerUser bounds
+// the open streams per user (per client IP when anonymous) on the route;
+// negative means unlimited. Browsers cannot set headers on WebSocket or
+// EventSource requests, so with QueryToken the handshake may carry the
+// token as ?access_token=, which is moved into Authorization and never
+// forwarded.
+type StreamConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	MaxPerUser  int           `yaml:"max_per_user"`
+	QueryToken  bool          `yaml:"query_token"`
+}
+
+// CORSConfig lets browsers on AllowedOrigins call a route. Origins are
+// exact (https://app.example.com), "*" for any origin (not allowed with
+// credentials) or a subdomain wildcard (https://*.example.com). No origins
+// disables CORS.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"` // "*" allows whatever the preflight asks for
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"` // how long browsers may cache a preflight
+}
+
+// SecurityHeadersConfig holds the values of browser security headers added
+// to every response of a route, replacing any the upstream sent. Empty
+// values are not sent.
+type SecurityHeadersConfig struct {
+	StrictTransportSecurity string `yaml:"strict_transport_security"`
+	ContentTypeOptions      string `yaml:"content_type_options"`
+	FrameOptions            string `yaml:"frame_options"`
+	ReferrerPolicy          string `yaml:"referrer_policy"`
+	ContentSecurityPolicy   string `yaml:"content_security_policy"`
+}
+
+type RouteConfig struct {
+	Name       string        `yaml:"name"`
+	PathPrefix string        `yaml:"path_prefix"`
+	Upstream   string        `yaml:"upstream"`
+	Methods    []string      `yaml:"methods"` // empty allows every method
+	Auth       bool          `yaml:"auth"`
+	Timeout    time.Duration `yaml:"timeout"`
+
+	// StripPrefix removes PathPrefix before forwarding; AddPrefix is then
+	// prepended. Together they rewrite e.g. /api/books -> /v1/books.
+	StripPrefix bool   `yaml:"strip_prefix"`
+	AddPrefix   string `yaml:"add_prefix"`
+
+	// Scopes that personal access tokens need on this route. If both are
+	// empty, PATs are not accepted here.
+	Scopes ScopeConfig `yaml:"scopes"`
+
+	Retries   RetryConfig      `yaml:"retries"`
+	RateLimit RateLimitConfig  `yaml:"rate_limit"`
+	Cache     RouteCacheConfig `yaml:"cache"`
+	Streaming StreamConfig     `yaml:"streaming"`
+
+	// Invalidates names cached routes whose responses successful writes
+	// through this route may change, e.g. a sync endpoint writing books.
+	Invalidates []string `yaml:"invalidates"`
+
+	// Compose makes this a composition route: instead of proxying to
+	// Upstream it fetches every part concurrently and merges the JSON.
+	Compose []ComposePartConfig `yaml:"compose"`
+
+	// override the top-level cors and security_headers as a whole
+	CORS            *CORSConfig            `yaml:"cors"`
+	SecurityHeaders *SecurityHeadersConfig `yaml:"security_headers"`
+}
+
+// ComposePartConfig is one GET request of a composition route. Its JSON
+// response becomes the Name field of the merged response. Timeout
+// defaults to the route's.
+type ComposePartConfig struct {
+	Name     string        `yaml:"name"`
+	Upstream string        `yaml:"upstream"`
+	Path     string        `yaml:"path"` // may carry a query string
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type ScopeConfig struct {
+	Read  string `yaml:"read"`
+	Write string `yaml:"write"`
+}
+
+// LoadConfig reads and validates the config file at path. JSON is accepted
+// as well since it is a subset of YAML.
+func LoadConfig(path string) (*GatewayConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func ParseConfig(raw []byte) (*GatewayConfig, error) {
+	// env values are substituted into the parsed document, never into the
+	// YAML text, so PEM keys and secrets with : # or quotes stay one value
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	expandEnvNode(&doc)
+	expanded, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg GatewayConfig
+	dec := yaml.NewDecoder(bytes.NewReader(expanded))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the config for mistakes and fills in defaults.
+func (cfg *GatewayConfig) Validate() error {
+	var errs []string
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Sprintf(format, args...)) }
+
+	for name, up := range cfg.Upstreams {
+		if up.URL != "" {
+			up.Targets = append([]string{up.URL}, up.Targets...)
+			up.URL = ""
+		}
+		if len(up.Targets) == 0 {
+			fail("upstream %q: no url or targets", name)
+		}
+		for _, t := range up.Targets {
+			u, err := url.Parse(t)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("upstream %q: invalid target url %q", name, t)
+			}
+		}
+
+		switch up.Strategy {
+		case "":
+			up.Strategy = StrategyRoundRobin
+		case StrategyRoundRobin, StrategyLeastConn, StrategyConsistentHash:
+		default:
+			fail("upstream %q: unknown strategy %q", name, up.Strategy)
+		}
+
+		hc := &up.HealthCheck
+		if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+			fail("upstream %q: health_check.path must start with /", name)
+		}
+		setDefault(&hc.Interval, 10*time.Second)
+		setDefault(&hc.Timeout, 2*time.Second)
+		setDefault(&hc.HealthyThreshold, 2)
+		setDefault(&hc.UnhealthyThreshold, 3)
+
+		setDefault(&up.Ejection.MaxFailures, 5)
+		setDefault(&up.Ejection.Duration, 30*time.Second)
+
+		cb := &up.CircuitBreaker
+		if cb.FailureThreshold == 0 {
+			cb.FailureThreshold = 5
+		}
+		setDefault(&cb.OpenTimeout, 30*time.Second)
+		setDefault(&cb.HalfOpenRequests, 1)
+
+		cfg.Upstreams[name] = up
+	}
+
+	if len(cfg.Routes) == 0 {
+		fail("no routes defined")
+	}
+	if err := cfg.CORS.validate(); err != nil {
+		fail("cors: %v", err)
+	}
+	names := map[string]bool{}
+	prefixes := map[string]string{}
+	needsAuth := false
+	for i := range cfg.Routes {
+		rt := &cfg.Routes[i]
+		if rt.Name == "" {
+			fail("route #%d: name is required", i+1)
+		} else if names[rt.Name] {
+			fail("route %q: duplicate name", rt.Name)
+		}
+		names[rt.Name] = true
+
+		if !strings.HasPrefix(rt.PathPrefix, "/") {
+			fail("route %q: path_prefix must start with /", rt.Name)
+		}
+		rt.PathPrefix = strings.TrimSuffix(rt.PathPrefix, "/")
+		if rt.PathPrefix == "" {
+			rt.PathPrefix = "/"
+		}
+		if other, ok := prefixes[rt.PathPrefix]; ok {
+			fail("route %q: path_prefix %s already used by %q", rt.Name, rt.PathPrefix, other)
+		}
+		prefixes[rt.PathPrefix] = rt.Name
+
+		if len(rt.Compose) > 0 {
+			cfg.validateCompose(rt, fail)
+		} else if _, ok := cfg.Upstreams[rt.Upstream]; !ok {
+			fail("route %q: unknown upstream %q", rt.Name, rt.Upstream)
+		}
+		for j, m := range rt.Methods {
+			m = strings.ToUpper(m)
+			if !validMethods[m] {
+				fail("route %q: invalid method %q", rt.Name, m)
+			}
+			rt.Methods[j] = m
+		}
+		if rt.AddPrefix != "" && !strings.HasPrefix(rt.AddPrefix, "/") {
+			fail("route %q: add_prefix must start with /", rt.Name)
+		}
+		if rt.Timeout < 0 {
+			fail("route %q: timeout must not be negative", rt.Name)
+		}
+		if rt.Timeout == 0 {
+			rt.Timeout = defaultRouteTimeout
+		}
+		rr := &rt.Retries
+		if rr.Attempts > 10 {
+			fail("route %q: retries.attempts must be at most 10", rt.Name)
+		}
+		setDefault(&rr.Attempts, 1)
+		setDefault(&rr.Backoff, 50*time.Millisecond)
+		setDefault(&rr.MaxBackoff, time.Second)
+		setDefault(&rr.MaxBodyBytes, 64<<10)
+
+		if rl := &rt.RateLimit; rl.Requests < 0 {
+			fail("route %q: rate_limit.requests must not be negative", rt.Name)
+		} else if rl.Requests > 0 {
+			setDefault(&rl.Period, time.Minute)
+			setDefault(&rl.Burst, rl.Requests)
+		}
+		if rt.Cache.TTL < 0 {
+			fail("route %q: cache.ttl must not be negative", rt.Name)
+		}
+		if st := &rt.Streaming; st.Enabled {
+			if st.IdleTimeout < 0 {
+				fail("route %q: streaming.idle_timeout must not be negative", rt.Name)
+			}
+			setDefault(&st.IdleTimeout, time.Minute)
+			if st.MaxPerUser == 0 {
+				st.MaxPerUser = 5
+			}
+		}
+		if rt.CORS == nil {
+			c := cfg.CORS
+			rt.CORS = &c
+		} else if err := rt.CORS.validate(); err != nil {
+			fail("route %q: cors: %v", rt.Name, err)
+		}
+		if rt.SecurityHeaders == nil {
+			rt.SecurityHeaders = &cfg.SecurityHeaders
+		}
+		needsAuth = needsAuth || rt.Auth
+	}
+	for _, rt := range cfg.Routes {
+		for _, name := range rt.Invalidates {
+			if other := cfg.route(name); other == nil || !other.Cache.Enabled {
+				fail("route %q: invalidates %q, which is not a cached route", rt.Name, name)
+			}
+		}
+	}
+
+	setDefault(&cfg.ResponseCache.MaxEntries, 10000)
+	setDefault(&cfg.ResponseCache.MaxBytes, 64<<20)
+	setDefault(&cfg.ResponseCache.MaxObjectBytes, 1<<20)
+
+	cfg.Auth.Algo = strings.ToUpper(cfg.Auth.Algo)
+	setDefault(&cfg.Auth.Cache.MaxEntries, 10000)
+	setDefault(&cfg.Auth.Cache.MaxTTL, time.Minute)
+	setDefault(&cfg.Auth.Cache.NegativeTTL, 30*time.Second)
+	if cfg.Auth.Enabled && needsAuth {
+		if err := cfg.Auth.validate(); err != nil {
+			fail("auth: %v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid gateway config:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+func (a *AuthConfig) validate() error {
+	if a.IntrospectURL != "" {
+		return nil
+	}
+	switch a.Algo {
+	case "HS256":
+		if a.HSSecret == "" {
+			return fmt.Errorf("HS256 requires hs_secret")
+		}
+	case "RS256":
+		if _, err := jwt.ParseRSAPublicKeyFromPEM([]byte(a.RSPublicKey)); err != nil {
+			return fmt.Errorf("RS256 requires a valid rs_public_key: %v", err)
+		}
+	case "":
+		if a.HSSecret == "" && a.RSPublicKey == "" {
+			return fmt.Errorf("no hs_secret, rs_public_key or introspect_url configured")
+		}
+	default:
+		return fmt.Errorf("unsupported algo %q", a.Algo)
+	}
+	return nil
+}
+
+func (cfg *GatewayConfig) route(name string) *RouteConfig {
+	for i := range cfg.Routes {
+		if cfg.Routes[i].Name == name {
+			return &cfg.Routes[i]
+		}
+	}
+	return nil
+}
+
+// validateCompose runs before the route's methods and timeout are checked;
+// part timeouts inherit the route timeout once it has its default.
+func (cfg *GatewayConfig) validateCompose(rt *RouteConfig, fail func(string, ...any)) {
+	if rt.Upstream != "" {
+		fail("route %q: upstream and compose are exclusive", rt.Name)
+	}
+	if rt.Cache.Enabled {
+		fail("route %q: composition routes cannot be cached", rt.Name)
+	}
+	if rt.Streaming.Enabled {
+		fail("route %q: composition routes cannot stream", rt.Name)
+	}
+	if len(rt.Methods) == 0 {
+		rt.Methods = []string{http.MethodGet}
+	}
+	for _, m := range rt.Methods {
+		if m = strings.ToUpper(m); m != http.MethodGet && m != http.MethodHead {
+			fail("route %q: composition routes only serve GET", rt.Name)
+		}
+	}
+	timeout := rt.Timeout
+	if timeout <= 0 {
+		timeout = defaultRouteTimeout
+	}
+	names := map[string]bool{}
+	for i := range rt.Compose {
+		p := &rt.Compose[i]
+		switch {
+		case p.Name == "" || p.Name == "errors" || p.Name == "partial":
+			fail("route %q: compose part #%d needs a name other than errors or partial", rt.Name, i+1)
+		case names[p.Name]:
+			fail("route %q: duplicate compose part %q", rt.Name, p.Name)
+		}
+		names[p.Name] = true
+		if _, ok := cfg.Upstreams[p.Upstream]; !ok {
+			fail("route %q: compose part %q: unknown upstream %q", rt.Name, p.Name, p.Upstream)
+		}
+		if u, err := url.Parse(p.Path); err != nil || !strings.HasPrefix(p.Path, "/") || u.Host != "" {
+			fail("route %q: compose part %q: path must start with /", rt.Name, p.Name)
+		}
+		if p.Timeout <= 0 || p.Timeout > timeout {
+			p.Timeout = timeout
+		}
+	}
+}
+
+func (c *CORSConfig) validate() error {
+	// entries may be comma-separated lists, which is handy with ${VAR}
+	var origins []string
+	for _, o := range c.AllowedOrigins {
+		for _, o := range strings.Split(o, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				origins = append(origins, o)
+			}
+		}
+	}
+	c.AllowedOrigins = origins
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf(`allowed_origins "*" cannot be combined with allow_credentials`)
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(o, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("invalid origin %q", o)
+		}
+	}
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	for i, m := range c.AllowedMethods {
+		m = strings.ToUpper(m)
+		if !validMethods[m] {
+			return fmt.Errorf("invalid method %q", m)
+		}
+		c.AllowedMethods[i] = m
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = []string{"Authorization", "Content-Type", "X-Request-ID", "Idempotency-Key"}
+	}
+	if len(c.ExposedHeaders) == 0 {
+		c.ExposedHeaders = []string{"X-Request-ID", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Idempotent-Replayed"}
+	}
+	setDefault(&c.MaxAge, 10*time.Minute)
+	return nil
+}
+
+// setDefault replaces a zero or negative value with def.
+func setDefault[T int | time.Duration](v *T, def T) {
+	if *v <= 0 {
+		*v = def
+	}
+}
+
+var validMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// expandEnvNode runs expandEnv on every scalar value in the document.
+// Unquoted scalars are re-resolved afterwards, so enabled: ${FLAG:-true}
+// is still a bool; anything quoted stays a string.
+func expandEnvNode(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			expandEnvNode(c)
+		}
+	case yaml.MappingNode:
+		// keys are field and upstream names, only values are expanded
+		for i := 1; i < len(n.Content); i += 2 {
+			expandEnvNode(n.Content[i])
+		}
+	case yaml.ScalarNode:
+		if n.Tag != "!!str" || !strings.Contains(n.Value, "${") {
+			return
+		}
+		n.Value = expandEnv(n.Value)
+		if n.Style == 0 {
+			n.Tag = ""
+		}
+	}
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default}. Unlike os.ExpandEnv it
+// leaves a lone $ alone, which is common in secrets.
+func expandEnv(s string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:start])
+
+		expr := s[start+2 : start+end]
+		name, def, hasDef := strings.Cut(expr, ":-")
+		if v := os.Getenv(name); v != "" || !hasDef {
+			b.WriteString(v)
+		} else {
+			b.WriteString(def)
+		}
+		s = s[start+end+1:]
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsPolicy answers preflights and adds CORS headers for one route. It
+// runs before authentication, since browsers send preflights without
+// credentials, and its headers are also on the gateway's own error
+// responses so that front-ends can read them.
+type corsPolicy struct {
+	cfg       CORSConfig
+	anyOrigin bool
+	origins   map[string]bool
+	wildcards [][2]string // scheme:// and .domain of https://*.domain
+
+	methods      map[string]bool
+	anyHeader    bool
+	allowMethods string
+	allowHeaders string
+	expose       string
+	maxAge       string
+}
+
+// newCORSPolicy compiles cfg for a route; preflights only offer the
+// methods the route accepts. It returns nil when CORS is disabled.
+func newCORSPolicy(cfg *CORSConfig, routeMethods []string) *corsPolicy {
+	if cfg == nil || len(cfg.AllowedOrigins) == 0 {
+		return nil
+	}
+	methods := cfg.AllowedMethods
+	if len(routeMethods) > 0 {
+		methods = slices.DeleteFunc(slices.Clone(methods), func(m string) bool { return !slices.Contains(routeMethods, m) })
+	}
+	p := &corsPolicy{
+		cfg:          *cfg,
+		origins:      map[string]bool{},
+		methods:      map[string]bool{},
+		allowMethods: strings.Join(methods, ", "),
+		allowHeaders: strings.Join(cfg.AllowedHeaders, ", "),
+		expose:       strings.Join(cfg.ExposedHeaders, ", "),
+		maxAge:       strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+	for _, o := range cfg.AllowedOrigins {
+		switch scheme, host, _ := strings.Cut(o, "://"); {
+		case o == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(host, "*."):
+			p.wildcards = append(p.wildcards, [2]string{scheme + "://", host[1:]})
+		default:
+			p.origins[strings.ToLower(o)] = true
+		}
+	}
+	for _, m := range methods {
+		p.methods[m] = true
+	}
+	for _, h := range cfg.AllowedHeaders {
+		p.anyHeader = p.anyHeader || h == "*"
+	}
+	return p
+}
+
+func (p *corsPolicy) allowed(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if rest, ok := strings.CutPrefix(origin, w[0]); ok && strings.HasSuffix(rest, w[1]) && len(rest) > len(w[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle adds the CORS response headers and reports whether the request
+// was a preflight, which it answers itself.
+func (p *corsPolicy) handle(c *gin.Context) (done bool) {
+	h := c.Writer.Header()
+	h.Add("Vary", "Origin")
+
+	origin := c.GetHeader("Origin")
+	reqMethod := c.GetHeader("Access-Control-Request-Method")
+	preflight := c.Request.Method == http.MethodOptions && reqMethod != ""
+	if origin == "" {
+		return false
+	}
+	if !p.allowed(origin) {
+		if preflight {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		}
+		return preflight
+	}
+
+	if p.anyOrigin && !p.cfg.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.cfg.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", p.expose)
+		return false
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if !p.methods[strings.ToUpper(reqMethod)] {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "method not allowed by CORS policy"})
+		return true
+	}
+	h.Set("Access-Control-Allow-Methods", p.allowMethods)
+	if p.anyHeader {
+		if req := c.GetHeader("Access-Control-Request-Headers"); req != "" {
+			h.Set("Access-Control-Allow-Headers", req)
+		}
+	} else {
+		h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	h.Set("Access-Control-Max-Age", p.maxAge)
+	c.AbortWithStatus(http.StatusNoContent)
+	return true
+}
+
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers", "Access-Control-Expose-Headers", "Access-Control-Max-Age",
+}
+
+// securityHeaders turns cfg into the headers to set, skipping empty values.
+func securityHeaders(cfg *SecurityHeadersConfig) http.Header {
+	h := http.Header{}
+	if cfg == nil {
+		return h
+	}
+	for name, v := range map[string]string{
+		"Strict-Transport-Security": cfg.StrictTransportSecurity,
+		"X-Content-Type-Options":    cfg.ContentTypeOptions,
+		"X-Frame-Options":           cfg.FrameOptions,
+		"Referrer-Policy":           cfg.ReferrerPolicy,
+		"Content-Security-Policy":   cfg.ContentSecurityPolicy,
+	} {
+		if v != "" {
+			h.Set(name, v)
+		}
+	}
+	return h
+}
+
+// stripManagedHeaders drops upstream headers that the gateway sets itself
+// for route, so a response never carries two conflicting values.
+func stripManagedHeaders(route RouteConfig, h http.Header) {
+	if route.CORS != nil && len(route.CORS.AllowedOrigins) > 0 {
+		for _, name := range corsResponseHeaders {
+			h.Del(name)
+		}
+	}
+	for name := range securityHeaders(route.SecurityHeaders) {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Health serves the gateway's probes. /livez and /readyz are about the
+// gateway process itself, so a failing upstream never takes the gateway
+// out of rotation; /healthz aggregates the readiness of every upstream for
+// dashboards and humans.
+type Health struct {
+	configs  *ConfigManager
+	draining atomic.Bool
+}
+
+func NewHealth(configs *ConfigManager) *Health {
+	return &Health{configs: configs}
+}
+
+// Drain makes readiness fail once shutdown has started.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Health) Readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Healthz is 200 only while every upstream is ready, 503 otherwise.
+func (h *Health) Healthz(c *gin.Context) {
+	upstreams := h.configs.Config().router.upstreams
+	report := make(map[string]UpstreamHealth, len(upstreams))
+	status, code := "ok", http.StatusOK
+	for name, u := range upstreams {
+		report[name] = u.Health()
+		if !report[name].Ready {
+			status, code = "degraded", http.StatusServiceUnavailable
+		}
+	}
+	if h.draining.Load() {
+		status, code = "shutting down", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "time": time.Now(), "upstreams": report})
+}
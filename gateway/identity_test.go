@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSetIdentityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		userID     string
+		role       string
+		scopes     []string
+		secret     string
+		wantUser   string
+		wantScopes string
+		wantSigned bool
+	}{
+		{"anonymous, unsigned", "", "", nil, "", "", "", false},
+		{"anonymous, signed", "", "", nil, "s3cret", "", "", true},
+		{"user", "u1", "user", nil, "s3cret", "u1", "", true},
+		{"token with scopes", "u1", "user", []string{"books:read", "books:write"}, "s3cret", "u1", "books:read books:write", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:4000"
+			// everything the client sends must be replaced
+			for _, name := range identityHeaders {
+				req.Header.Set(name, "forged")
+			}
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+			if tt.userID != "" {
+				c.Set("userID", tt.userID)
+				c.Set("role", tt.role)
+				c.Set("scopes", tt.scopes)
+			}
+
+			h := req.Header.Clone()
+			setIdentityHeaders(c, h, tt.secret)
+
+			if got := h.Get(HeaderUserID); got != tt.wantUser {
+				t.Errorf("%s = %q, want %q", HeaderUserID, got, tt.wantUser)
+			}
+			if got := h.Get(HeaderUserScopes); got != tt.wantScopes {
+				t.Errorf("%s = %q, want %q", HeaderUserScopes, got, tt.wantScopes)
+			}
+			if got := h.Get(HeaderClientIP); got != "192.0.2.1" {
+				t.Errorf("%s = %q, want the peer address", HeaderClientIP, got)
+			}
+
+			sig, ts := h.Get(HeaderIdentitySignature), h.Get(HeaderIdentityTimestamp)
+			if !tt.wantSigned {
+				if sig != "" || ts != "" {
+					t.Errorf("unsigned request carries signature %q, timestamp %q", sig, ts)
+				}
+				return
+			}
+			unix, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil || time.Since(time.Unix(unix, 0)) > time.Minute {
+				t.Errorf("timestamp %q is not the current time", ts)
+			}
+			want := signIdentity(tt.secret, tt.wantUser, h.Get(HeaderUserRole), tt.wantScopes, "192.0.2.1", ts)
+			if sig != want {
+				t.Errorf("signature %q, want %q", sig, want)
+			}
+		})
+	}
+}
+
+func TestSignIdentityCoversEveryField(t *testing.T) {
+	base := signIdentity("s3cret", "u1", "user", "books:read", "192.0.2.1", "1700000000")
+	variants := map[string]string{
+		"secret":    signIdentity("other", "u1", "user", "books:read", "192.0.2.1", "1700000000"),
+		"user":      signIdentity("s3cret", "u2", "user", "books:read", "192.0.2.1", "1700000000"),
+		"role":      signIdentity("s3cret", "u1", "admin", "books:read", "192.0.2.1", "1700000000"),
+		"scopes":    signIdentity("s3cret", "u1", "user", "", "192.0.2.1", "1700000000"),
+		"client ip": signIdentity("s3cret", "u1", "user", "books:read", "192.0.2.2", "1700000000"),
+		"timestamp": signIdentity("s3cret", "u1", "user", "books:read", "192.0.2.1", "1700000001"),
+		// the separator keeps fields from sliding into each other
+		"shifted": signIdentity("s3cret", "u1\nuser", "", "books:read", "192.0.2.1", "1700000000"),
+	}
+	for field, sig := range variants {
+		if sig == base {
+			t.Errorf("changing the %s does not change the signature", field)
+		}
+	}
+}
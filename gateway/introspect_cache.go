@@ -0,0 +1,168 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	introspectionLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_introspection_cache_lookups_total",
+		Help: "Token introspection cache lookups by result (hit, negative_hit, miss).",
+	}, []string{"result"})
+	introspectionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_introspection_duration_seconds",
+		Help:    "Latency of calls to token introspection endpoints.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+)
+
+// expiryMargin keeps a cached result from being used right up to the
+// token's exp, so a forwarded access token doesn't expire in flight.
+const expiryMargin = 5 * time.Second
+
+// IntrospectionCache remembers introspection results so that not every
+// request costs a round trip to the identity provider. Entries are keyed by
+// a hash of endpoint and token, never the token itself. Active results live
+// until the token's exp (at most MaxTTL, which bounds how long a revoked
+// token keeps working); inactive ones for NegativeTTL. Concurrent lookups
+// of the same token share one call.
+type IntrospectionCache struct {
+	cfg IntrospectionCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+
+	group singleflight.Group
+}
+
+type cacheEntry struct {
+	key     string
+	res     *introspection
+	expires time.Time
+}
+
+func NewIntrospectionCache(cfg IntrospectionCacheConfig) *IntrospectionCache {
+	return &IntrospectionCache{cfg: cfg, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// Introspect returns the cached result for token or asks endpoint.
+// Transport and server errors are never cached.
+func (c *IntrospectionCache) Introspect(endpoint, serviceToken, token string) (*introspection, error) {
+	sum := sha256.Sum256([]byte(endpoint + "\x00" + token))
+	key := hex.EncodeToString(sum[:])
+
+	if res, ok := c.get(key); ok {
+		if res.Active {
+			introspectionLookups.WithLabelValues("hit").Inc()
+		} else {
+			introspectionLookups.WithLabelValues("negative_hit").Inc()
+		}
+		return res, nil
+	}
+	introspectionLookups.WithLabelValues("miss").Inc()
+
+	v, err, _ := c.group.Do(key, func() (any, error) {
+		start := time.Now()
+		res, err := introspectToken(endpoint, serviceToken, token)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		introspectionDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+		if err != nil {
+			return nil, err
+		}
+		c.put(key, res)
+		return res, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*introspection), nil
+}
+
+func (c *IntrospectionCache) get(key string) (*introspection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.res, true
+}
+
+func (c *IntrospectionCache) put(key string, res *introspection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	expires := now.Add(c.cfg.NegativeTTL)
+	if res.Active {
+		expires = now.Add(c.cfg.MaxTTL)
+		if res.Exp > 0 {
+			if exp := time.Unix(res.Exp, 0).Add(-expiryMargin); exp.Before(expires) {
+				expires = exp
+			}
+		}
+	}
+	if !expires.After(now) {
+		return
+	}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = &cacheEntry{key: key, res: res, expires: expires}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, res: res, expires: expires})
+	c.evict()
+}
+
+func (c *IntrospectionCache) evict() {
+	for c.lru.Len() > c.cfg.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Configure applies the limits of a new config. Entries cached under
+// longer TTLs keep their expiry; ones beyond MaxEntries are evicted.
+func (c *IntrospectionCache) Configure(cfg IntrospectionCacheConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+	c.evict()
+}
+
+// Len reports the number of cached results.
+func (c *IntrospectionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Purge drops every cached result, e.g. after revoking tokens.
+func (c *IntrospectionCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"platform/logging"
+	"platform/request"
+	"platform/tracing"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,44 +20,139 @@ import (
 )
 
 func main() {
+	if err := logging.Setup(serviceName); err != nil {
+		log.Fatalf("failed to set up logging: %v", err)
+	}
+
 	// Read optional config from env
-	userSvc := getEnv("USER_SERVICE_URL", "http://user-service:8080")
-	bookSvc := getEnv("BOOK_SERVICE_URL", "http://book-service:8081")
+	configPath := getEnv("GATEWAY_CONFIG", "routes.yaml")
 	addr := getEnv("GATEWAY_ADDR", ":8000")
-	enableAuth := getEnv("GATEWAY_AUTH_ENABLED", "true") == "true"
+	adminAddr := getEnv("GATEWAY_ADMIN_ADDR", ":9000")
+	adminToken := os.Getenv("GATEWAY_ADMIN_TOKEN")
+	pollInterval, err := time.ParseDuration(getEnv("GATEWAY_CONFIG_POLL_INTERVAL", "5s"))
+	if err != nil {
+		log.Fatalf("invalid GATEWAY_CONFIG_POLL_INTERVAL: %v", err)
+	}
+	shutdownTimeout, err := time.ParseDuration(getEnv("GATEWAY_SHUTDOWN_TIMEOUT", "15s"))
+	if err != nil {
+		log.Fatalf("invalid GATEWAY_SHUTDOWN_TIMEOUT: %v", err)
+	}
+	// how long /readyz fails on SIGTERM before the listeners close
+	preStopDelay, err := time.ParseDuration(getEnv("GATEWAY_PRESTOP_DELAY", "5s"))
+	if err != nil {
+		log.Fatalf("invalid GATEWAY_PRESTOP_DELAY: %v", err)
+	}
+	// comma-separated IPs or CIDRs of load balancers in front of the
+	// gateway; only they may set the client IP through X-Forwarded-For
+	trustedProxies := strings.FieldsFunc(os.Getenv("GATEWAY_TRUSTED_PROXIES"), func(r rune) bool { return r == ',' || r == ' ' })
+
+	// rate limits, drained targets, open streams and caches outlive config reloads
+	shared := &Shared{
+		RateLimits:     NewMemoryRateLimitStore(),
+		RateLimitStats: NewRateLimitStats(),
+		Drains:         NewDrainSet(),
+		Streams:        NewStreamTracker(),
+		Responses:      NewResponseCache(ResponseCacheConfig{}),
+		Tokens:         NewIntrospectionCache(IntrospectionCacheConfig{}),
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName)
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	configs, err := NewConfigManager(configPath, shared)
+	if err != nil {
+		log.Fatalf("failed to load gateway config: %v", err)
+	}
+
+	// Reload on SIGHUP and whenever the file changes; stop on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go configs.Watch(ctx, pollInterval)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			configs.reloadAndLog("SIGHUP")
+		}
+	}()
 
 	// Gin router
 	r := gin.New()
-	r.Use(gin.Recovery())
-	r.Use(BasicLoggerMiddleware())
+	// the client IP keys rate limits and load balancing, so it must not
+	// come from a header anyone can set
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("invalid GATEWAY_TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(request.IDMiddleware(), logging.Middleware(), tracing.Middleware(serviceName), MetricsMiddleware())
+	// proxy and handler panics are recovered here, inside the access log,
+	// span and metrics, which then record the 500 like any other response
+	r.Use(logging.Recovery())
 
 	// Health & metrics
-	r.GET("/healthz", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok", "time": time.Now()}) })
+	health := NewHealth(configs)
+	r.GET("/livez", health.Livez)
+	r.GET("/readyz", health.Readyz)
+	r.GET("/healthz", health.Healthz)
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	if userSvc == "" || bookSvc == "" {
-		log.Fatalf("service URL not set: user=%q book=%q", userSvc, bookSvc)
-	}
-
-	r.POST("/register", ProxyHandler(userSvc))
-	r.POST("/login", ProxyHandler(userSvc))
+	// Everything else is proxied according to the active route table
+	r.NoRoute(configs.Handle)
 
-	// JWT middleware (applies to proxied endpoints)
-	var jwtMiddleware gin.HandlerFunc
-	if enableAuth {
-		jwtMiddleware = JWTMiddleware() // validates token or calls introspect based on env
+	active := configs.Config()
+	for _, rc := range active.cfg.Routes {
+		target := rc.Upstream
+		if len(rc.Compose) > 0 {
+			var parts []string
+			for _, p := range rc.Compose {
+				parts = append(parts, p.Name+"="+p.Upstream+":"+p.Path)
+			}
+			target = "compose(" + strings.Join(parts, ", ") + ")"
+		}
+		log.Printf("route %-14s %-16s -> %s (auth=%v)", rc.Name, rc.PathPrefix, target, rc.Auth && active.cfg.Auth.Enabled)
+	}
+	servers := []*http.Server{{Addr: addr, Handler: r, ReadHeaderTimeout: 10 * time.Second}}
+	servers[0].RegisterOnShutdown(shared.Streams.CloseAll)
+	log.Printf("Gateway listening on %s with %d routes from %s (version %s)", addr, len(active.cfg.Routes), configPath, active.version)
+	// The admin API gets its own listener so it can stay off the public network
+	if adminToken != "" {
+		admin := NewAdminServer(configs, shared, adminToken)
+		servers = append(servers, &http.Server{Addr: adminAddr, Handler: admin, ReadHeaderTimeout: 10 * time.Second})
+		log.Printf("Admin API listening on %s", adminAddr)
 	} else {
-		jwtMiddleware = func(c *gin.Context) { c.Next() }
+		log.Printf("GATEWAY_ADMIN_TOKEN not set, admin API disabled")
+	}
+	for _, srv := range servers {
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("gateway failed on %s: %v", srv.Addr, err)
+			}
+		}()
 	}
 
-	// Proxy routes
-	r.Any("/users/*path", jwtMiddleware, ProxyHandler(userSvc))
-	r.Any("/books/*path", jwtMiddleware, ProxyHandler(bookSvc))
+	// Fail readiness, then let requests in flight finish
+	<-ctx.Done()
+	log.Printf("shutting down: readiness off for %s, then draining for up to %s", preStopDelay, shutdownTimeout)
+	health.Drain()
+	// new requests keep arriving until the load balancer sees /readyz fail
+	time.Sleep(preStopDelay)
 
-	log.Printf("Gateway listening on %s (proxying users -> %s, books -> %s). Auth enabled=%v", addr, userSvc, bookSvc, enableAuth)
-	if err := r.Run(addr); err != nil {
-		log.Fatalf("gateway failed: %v", err)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("shutdown %s: %v", srv.Addr, err)
+			}
+		}()
 	}
+	wg.Wait()
+	configs.Config().router.Close()
+	log.Printf("gateway stopped")
 }
 
 func getEnv(key, def string) string {
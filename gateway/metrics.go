@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_http_requests_total",
+		Help: "Requests handled by the gateway by route, method, status and upstream.",
+	}, []string{"route", "method", "status", "upstream"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_http_request_duration_seconds",
+		Help:    "Time from receiving a request to finishing the response, by route, method, status and upstream.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"route", "method", "status", "upstream"})
+	httpInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_http_requests_in_flight",
+		Help: "Requests currently being handled, by route.",
+	}, []string{"route"})
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_upstream_errors_total",
+		Help: "Failed upstream attempts by upstream and kind (connect, timeout, status_5xx, circuit_open, no_healthy_target).",
+	}, []string{"upstream", "kind"})
+)
+
+// MetricsMiddleware records request counts and latency. Proxied requests
+// are labelled with the gateway route and upstream set by the Router; the
+// gateway's own endpoints use their path template and everything else is
+// "unmatched", which keeps label cardinality bounded.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.GetString("route")
+		if route == "" {
+			route = c.FullPath()
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := prometheus.Labels{
+			"route":    route,
+			"method":   c.Request.Method,
+			"status":   strconv.Itoa(c.Writer.Status()),
+			"upstream": c.GetString("upstream"),
+		}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	}
+}
+
+// upstreamErrorKind classifies a failed attempt for gateway_upstream_errors_total.
+func upstreamErrorKind(err error) string {
+	var openErr *CircuitOpenError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &openErr):
+		return "circuit_open"
+	case errors.Is(err, errNoHealthyTarget):
+		return "no_healthy_target"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "connect"
+}
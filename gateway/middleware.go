@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
 	"time"
 
@@ -15,24 +15,43 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func BasicLoggerMiddleware() gin.HandlerFunc {
+// AdminAuthMiddleware protects operational endpoints with a static bearer
+// token (GATEWAY_ADMIN_TOKEN). Without a token configured they are disabled.
+func AdminAuthMiddleware(token string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "admin API disabled"})
+			return
+		}
+		got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
 		c.Next()
-		status := c.Writer.Status()
-		log.Printf("%s %s -> %d", c.Request.Method, c.Request.URL.Path, status)
 	}
 }
 
-// JWTMiddleware validates token and sets "userID" in context if OK.
-// Behavior controlled by env:
-// - AUTH_INTROSPECT_URL (if set) -> calls introspection endpoint (POST token=...)
-// - AUTH_ALGO (HS256 or RS256) and AUTH_HS_SECRET or AUTH_RS_PUBKEY (PEM) -> local verify
-func JWTMiddleware() gin.HandlerFunc {
-	introspectURL := os.Getenv("AUTH_INTROSPECT_URL")
-	algo := os.Getenv("AUTH_ALGO") // e.g. HS256 or RS256
+// patPrefix identifies personal access tokens issued by user-service.
+const patPrefix = "blp_"
+
+// JWTMiddleware validates token and sets "userID" in context if OK. Like the
+// other per-route middlewares it never calls c.Next(), so the router can run
+// it as one step of a route's pipeline.
+// Behavior controlled by the auth section of the gateway config:
+// - introspect_url (if set) -> calls introspection endpoint (POST token=...)
+// - algo (HS256 or RS256) and hs_secret or rs_public_key (PEM) -> local verify
+// Personal access tokens (blp_...) are always resolved through user-service
+// at pat_introspect_url, authenticated with service_token.
+// Introspection results are kept in cache (sized by auth.cache).
+func JWTMiddleware(cfg AuthConfig, cache *IntrospectionCache) gin.HandlerFunc {
+	introspectURL := cfg.IntrospectURL
+	patIntrospectURL := cfg.PATIntrospectURL
+	serviceToken := cfg.ServiceToken
+	algo := cfg.Algo // e.g. HS256 or RS256
 
-	hsSecret := os.Getenv("AUTH_HS_SECRET")
-	rsPubKeyPEM := os.Getenv("AUTH_RS_PUBKEY") // PEM string
+	hsSecret := cfg.HSSecret
+	rsPubKeyPEM := cfg.RSPublicKey // PEM string
 
 	var rsKey any
 	if rsPubKeyPEM != "" {
@@ -58,20 +77,40 @@ func JWTMiddleware() gin.HandlerFunc {
 		}
 		tokenStr := parts[1]
 
+		// Personal access token: resolve via user-service and swap in the
+		// short-lived scoped JWT it returns, since that's what the backends verify.
+		// Cache hits don't reach user-service, so the token's last_used_at
+		// only moves on a miss, at most auth.cache.max_ttl apart.
+		if strings.HasPrefix(tokenStr, patPrefix) {
+			res, err := cache.Introspect(patIntrospectURL, serviceToken, tokenStr)
+			if err != nil || !res.Active || res.AccessToken == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token invalid"})
+				return
+			}
+			c.Set("userID", res.subject())
+			if res.Role != "" {
+				c.Set("role", res.Role)
+			}
+			c.Set("scopes", strings.Fields(res.Scope))
+			c.Request.Header.Set("Authorization", "Bearer "+res.AccessToken)
+			return
+		}
+
 		// Option A: introspection
 		if introspectURL != "" {
-			active, sub, err := introspectToken(introspectURL, tokenStr)
-			if err != nil || !active {
+			res, err := cache.Introspect(introspectURL, "", tokenStr)
+			if err != nil || !res.Active {
 				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token invalid"})
 				return
 			}
 			// set user id (subject) in context
-			c.Set("userID", sub)
-			c.Next()
+			c.Set("userID", res.subject())
+			if res.Role != "" {
+				c.Set("role", res.Role)
+			}
 			return
 		}
 
-		// Option B: local verification
 		// Option B: local verification
 		var keyFunc jwt.Keyfunc
 		switch algo {
@@ -133,8 +172,10 @@ func JWTMiddleware() gin.HandlerFunc {
 			if sub != "" {
 				c.Set("userID", sub)
 			}
+			if role, ok := claims["role"].(string); ok && role != "" {
+				c.Set("role", role)
+			}
 		}
-		c.Next()
 	}
 }
 
@@ -153,35 +194,76 @@ func toString(v any) string {
 	}
 }
 
-// introspectToken calls a token introspection endpoint. Expect JSON { active: bool, sub: "..." }
-func introspectToken(url string, token string) (active bool, subject string, err error) {
-	form := "token=" + token
-	req, _ := http.NewRequest("POST", url, bytes.NewBufferString(form))
+// ScopeMiddleware restricts personal access tokens on a route. Safe methods
+// need readScope or writeScope, everything else writeScope. With both empty,
+// PATs are rejected outright. Requests authenticated with a regular JWT
+// carry no scopes and are not affected.
+func ScopeMiddleware(readScope, writeScope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, exists := c.Get("scopes")
+		if !exists {
+			return
+		}
+
+		required := writeScope
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			required = readScope
+		}
+		for _, s := range v.([]string) {
+			if required != "" && (s == required || s == writeScope) {
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token scope does not allow this operation"})
+	}
+}
+
+type introspection struct {
+	Active bool   `json:"active"`
+	Sub    string `json:"sub"`
+	// many introspect endpoints return "username" or "user_id" etc
+	UserID      string `json:"user_id"`
+	Role        string `json:"role"`
+	Scope       string `json:"scope"`
+	Exp         int64  `json:"exp"`
+	AccessToken string `json:"access_token"` // set by user-service for personal access tokens
+}
+
+func (i *introspection) subject() string {
+	if i.Sub != "" {
+		return i.Sub
+	}
+	return i.UserID
+}
+
+// introspectionClient is shared so connections to the introspection
+// endpoints are reused.
+var introspectionClient = &http.Client{Timeout: 5 * time.Second}
+
+// introspectToken calls a token introspection endpoint (RFC 7662). serviceToken,
+// if set, is sent as X-Service-Token.
+func introspectToken(endpoint, serviceToken, token string) (*introspection, error) {
+	form := url.Values{"token": {token}}.Encode()
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form))
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+	if serviceToken != "" {
+		req.Header.Set("X-Service-Token", serviceToken)
+	}
+	resp, err := introspectionClient.Do(req)
 	if err != nil {
-		return false, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != 200 {
-		return false, "", fmt.Errorf("introspect failed: %s", string(body))
-	}
-	var out struct {
-		Active bool   `json:"active"`
-		Sub    string `json:"sub"`
-		// many introspect endpoints return "username" or "user_id" etc
-		UserID string `json:"user_id"`
+		return nil, fmt.Errorf("introspect failed: %s", string(body))
 	}
+	var out introspection
 	if err := json.Unmarshal(body, &out); err != nil {
-		return false, "", err
-	}
-	if out.Sub != "" {
-		return out.Active, out.Sub, nil
-	}
-	if out.UserID != "" {
-		return out.Active, out.UserID, nil
+		return nil, err
 	}
-	return out.Active, "", nil
+	return &out, nil
 }
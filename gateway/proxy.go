@@ -1,43 +1,346 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ProxyHandler(target string) gin.HandlerFunc {
-	u, err := url.Parse(target)
-	if err != nil {
-		panic(err)
-	}
-	proxy := httputil.NewSingleHostReverseProxy(u)
-
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
+type balanceKeyCtx struct{}
 
-		req.Host = u.Host
-		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/books")
-		if req.URL.Path == "" {
-			req.URL.Path = "/"
-		}
+// ProxyHandler forwards requests matched by route to one of the upstream's
+// targets, applying the route's path rewrite, timeout and retry policy.
+// The caller's identity is forwarded as signed X-User-* headers.
+func ProxyHandler(route RouteConfig, upstream *Upstream, transport http.RoundTripper, identitySecret string) gin.HandlerFunc {
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Path = rewritePath(route, req.URL.Path)
+			req.URL.RawPath = ""
+			// scheme and host are filled in by upstreamTransport once a
+			// target has been picked
+		},
+		Transport:    &upstreamTransport{upstream: upstream, base: transport, retries: route.Retries},
+		ErrorHandler: proxyErrorHandler(route),
 	}
 
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		// optionally modify responses here
+		stripManagedHeaders(route, resp.Header)
+		captureUpstreamResponse(resp)
+		if kind, ok := resp.Request.Context().Value(streamKindKey{}).(string); ok {
+			resp.Body = newIdleTimeoutBody(resp.Body, route.Streaming.IdleTimeout, func() {
+				streamIdleCloses.WithLabelValues(route.Name, kind).Inc()
+			})
+		}
 		return nil
 	}
 
 	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		// streams admitted by StreamHandler end by idle timeout instead
+		if _, stream := ctx.Value(streamKindKey{}).(string); route.Timeout > 0 && !stream {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+			defer cancel()
+		}
+		// consistent hashing keys on the user, or the client IP when anonymous
+		key := c.GetString("userID")
+		if key == "" {
+			key = c.ClientIP()
+		}
+		c.Request = c.Request.WithContext(context.WithValue(ctx, balanceKeyCtx{}, key))
+
+		if route.Retries.Attempts > 1 && idempotent(c.Request, route.Retries.IdempotencyKey) {
+			if err := bufferBody(c.Request, route.Retries.MaxBodyBytes); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+				return
+			}
+		}
+
 		// Pass request through to proxy
 		// Copy Authorization explicitly (should be present)
 		if auth := c.GetHeader("Authorization"); auth != "" {
 			c.Request.Header.Set("Authorization", auth)
 		}
+		setIdentityHeaders(c, c.Request.Header, identitySecret)
 		proxy.ServeHTTP(c.Writer, c.Request)
 	}
 }
+
+// proxyErrorHandler replaces ReverseProxy's bare 502 with a JSON error:
+// 503 while no target can take the request, 504 on timeouts and 502 for
+// everything else.
+func proxyErrorHandler(route RouteConfig) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		status, msg := http.StatusBadGateway, "upstream request failed"
+		var openErr *CircuitOpenError
+		var netErr net.Error
+		switch {
+		case errors.As(err, &openErr):
+			status, msg = http.StatusServiceUnavailable, "upstream temporarily unavailable"
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+		case errors.Is(err, errNoHealthyTarget):
+			status, msg = http.StatusServiceUnavailable, "no healthy upstream"
+		case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+			status, msg = http.StatusGatewayTimeout, "upstream timed out"
+		case errors.Is(err, context.Canceled):
+			// the client went away, nobody will read the response
+			w.WriteHeader(499)
+			return
+		}
+
+		slog.WarnContext(r.Context(), "proxy error",
+			"request_id", r.Header.Get("X-Request-ID"),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route.Name,
+			"upstream", route.Upstream,
+			"status", status,
+			"error", err,
+		)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(gin.H{"error": msg})
+	}
+}
+
+// upstreamTransport sends each request to a target picked from the
+// upstream pool, retrying idempotent requests on another target when
+// allowed, and reports outcomes for passive ejection and the breaker.
+type upstreamTransport struct {
+	upstream *Upstream
+	base     http.RoundTripper
+	retries  RetryConfig
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, _ := req.Context().Value(balanceKeyCtx{}).(string)
+
+	attempts := 1
+	if canReplay(req, t.retries.IdempotencyKey) {
+		attempts = t.retries.Attempts
+	}
+
+	var tried []*Target
+	for attempt := 1; ; attempt++ {
+		resp, target, err := t.try(req, key, tried, attempt)
+		if attempt >= attempts || !shouldRetry(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		tried = append(tried, target)
+
+		timer := time.NewTimer(backoff(t.retries, attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// try makes a single attempt, traced as a client span. Retries rewind the
+// request body first.
+func (t *upstreamTransport) try(req *http.Request, key string, tried []*Target, attempt int) (*http.Response, *Target, error) {
+	done, err := t.upstream.breaker.Allow()
+	if err != nil {
+		upstreamErrors.WithLabelValues(t.upstream.name, upstreamErrorKind(err)).Inc()
+		return nil, nil, err
+	}
+	target, err := t.upstream.Pick(key, tried)
+	if err != nil {
+		done(breakerIgnore)
+		upstreamErrors.WithLabelValues(t.upstream.name, upstreamErrorKind(err)).Inc()
+		return nil, nil, err
+	}
+
+	ctx, span := tracer.Start(req.Context(), req.Method+" "+t.upstream.name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.ServerAddress(target.URL.Hostname()),
+		semconv.URLPath(req.URL.Path),
+		attribute.Int("http.request.resend_count", attempt-1),
+	))
+	defer span.End()
+
+	out := req.Clone(ctx)
+	out.URL.Scheme = target.URL.Scheme
+	out.URL.Host = target.URL.Host
+	out.URL.Path = singleJoiningSlash(target.URL.Path, req.URL.Path)
+	out.Host = target.URL.Host
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(out.Header))
+	if attempt > 1 && req.GetBody != nil {
+		if out.Body, err = req.GetBody(); err != nil {
+			done(breakerIgnore)
+			return nil, target, err
+		}
+	}
+
+	target.inflight.Add(1)
+	resp, err := t.base.RoundTrip(out)
+	if err != nil {
+		target.inflight.Add(-1)
+		// a cancelled client request says nothing about the target
+		if errors.Is(req.Context().Err(), context.Canceled) {
+			done(breakerIgnore)
+		} else {
+			done(breakerFailure)
+			t.upstream.Report(target, false)
+			upstreamErrors.WithLabelValues(t.upstream.name, upstreamErrorKind(err)).Inc()
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, target, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	ok := resp.StatusCode < 500
+	t.upstream.Report(target, ok)
+	if ok {
+		done(breakerSuccess)
+	} else {
+		done(breakerFailure)
+		upstreamErrors.WithLabelValues(t.upstream.name, "status_5xx").Inc()
+		span.SetStatus(codes.Error, resp.Status)
+	}
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// ReverseProxy needs the raw body of an upgraded connection
+		target.inflight.Add(-1)
+		return resp, target, nil
+	}
+	// the connection stays busy until the body has been consumed
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: func() { target.inflight.Add(-1) }}
+	return resp, target, nil
+}
+
+// shouldRetry reports whether another target might do better. An open
+// breaker or an empty pool won't change within a backoff interval.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		var openErr *CircuitOpenError
+		return !errors.As(err, &openErr) && !errors.Is(err, errNoHealthyTarget)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff is exponential with jitter: a random duration between half and
+// all of Backoff * 2^(attempt-1), capped at MaxBackoff.
+func backoff(cfg RetryConfig, attempt int) time.Duration {
+	d := cfg.Backoff << (attempt - 1)
+	if d <= 0 || d > cfg.MaxBackoff {
+		d = cfg.MaxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// idempotent reports whether sending req twice has the effect of sending
+// it once: by its method, or, when keyDedup says the upstream deduplicates
+// requests by Idempotency-Key, because it carries one.
+func idempotent(req *http.Request, keyDedup bool) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return keyDedup && req.Header.Get("Idempotency-Key") != ""
+}
+
+// canReplay reports whether req may be sent more than once: it must be
+// idempotent and either have no body or one that bufferBody captured.
+// Protocol upgrades are never retried.
+func canReplay(req *http.Request, keyDedup bool) bool {
+	if !idempotent(req, keyDedup) || req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// bufferBody reads up to limit bytes of the request body into memory so it
+// can be replayed on retry. Larger bodies are left streaming and the
+// request simply won't be retried.
+func bufferBody(req *http.Request, limit int) error {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength > int64(limit) {
+		return nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(req.Body, int64(limit)+1))
+	if err != nil {
+		return err
+	}
+	if len(buf) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return nil
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return nil
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+	once bool
+}
+
+func (b *trackedBody) Close() error {
+	if !b.once {
+		b.once = true
+		b.done()
+	}
+	return b.ReadCloser.Close()
+}
+
+// rewritePath applies a route's strip_prefix/add_prefix rules.
+func rewritePath(route RouteConfig, path string) string {
+	if route.StripPrefix && route.PathPrefix != "/" {
+		path = strings.TrimPrefix(path, route.PathPrefix)
+	}
+	if route.AddPrefix != "" {
+		path = strings.TrimSuffix(route.AddPrefix, "/") + "/" + strings.TrimPrefix(path, "/")
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
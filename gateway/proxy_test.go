@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCanReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		key      string
+		keyDedup bool
+		upgrade  bool
+		want     bool
+	}{
+		{"GET", http.MethodGet, "", false, false, true},
+		{"DELETE", http.MethodDelete, "", false, false, true},
+		{"POST", http.MethodPost, "", true, false, false},
+		{"POST with key, upstream deduplicates", http.MethodPost, "k1", true, false, true},
+		{"POST with key, upstream ignores keys", http.MethodPost, "k1", false, false, false},
+		{"PATCH with key, upstream deduplicates", http.MethodPatch, "k1", true, false, true},
+		{"upgrade", http.MethodGet, "", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/books", strings.NewReader(`{"title":"x"}`))
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+			if tt.upgrade {
+				req.Header.Set("Upgrade", "websocket")
+			}
+			if err := bufferBody(req, 1024); err != nil {
+				t.Fatal(err)
+			}
+			if got := canReplay(req, tt.keyDedup); got != tt.want {
+				t.Errorf("canReplay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
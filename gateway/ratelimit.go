@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit is a token bucket: Burst tokens, refilled at Rate per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+// RateLimitStore keeps the buckets. The in-memory store limits each gateway
+// instance separately; a shared implementation (e.g. Redis) makes the
+// limits global across replicas.
+type RateLimitStore interface {
+	// Take refills the bucket for key and tries to remove one token.
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitMiddleware throttles a route per user, or per client IP for
+// anonymous requests. It runs after JWTMiddleware so userID is known.
+func RateLimitMiddleware(route string, cfg RateLimitConfig, store RateLimitStore, stats *RateLimitStats) gin.HandlerFunc {
+	limit := RateLimit{Rate: float64(cfg.Requests) / cfg.Period.Seconds(), Burst: cfg.Burst}
+	policy := strconv.Itoa(cfg.Requests) + ";w=" + strconv.Itoa(int(cfg.Period.Seconds()))
+
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if userID := c.GetString("userID"); userID != "" {
+			key = "user:" + userID
+		}
+
+		res, err := store.Take(c.Request.Context(), route+"|"+key, limit)
+		if err != nil {
+			// don't take the API down with the limiter
+			log.Printf("rate limit store error (route %s): %v", route, err)
+			stats.record(route, "errors")
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(res.Reset))
+		if !res.Allowed {
+			stats.record(route, "limited")
+			c.Header("Retry-After", ceilSeconds(res.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		stats.record(route, "allowed")
+	}
+}
+
+// RateLimitStats counts rate limit decisions per route for the admin API.
+// Like the buckets, the counts outlive config reloads.
+type RateLimitStats struct {
+	mu     sync.Mutex
+	routes map[string]map[string]int64 // route -> allowed / limited / errors
+}
+
+func NewRateLimitStats() *RateLimitStats {
+	return &RateLimitStats{routes: map[string]map[string]int64{}}
+}
+
+func (s *RateLimitStats) record(route, result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts, ok := s.routes[route]
+	if !ok {
+		counts = map[string]int64{"allowed": 0, "limited": 0, "errors": 0}
+		s.routes[route] = counts
+	}
+	counts[result]++
+}
+
+// Snapshot copies the counts.
+func (s *RateLimitStats) Snapshot() map[string]map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]map[string]int64, len(s.routes))
+	for route, counts := range s.routes {
+		out[route] = maps.Clone(counts)
+	}
+	return out
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// MemoryRateLimitStore keeps buckets in process memory.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket will be full, after which it can be dropped
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	return s.take(key, limit, time.Now()), nil
+}
+
+func (s *MemoryRateLimitStore) take(key string, limit RateLimit, now time.Time) RateLimitResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	res := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	b.full = now.Add(res.Reset)
+	return res
+}
+
+// sweep drops buckets that have refilled completely; they are
+// indistinguishable from new ones.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Len reports the number of live buckets.
+func (s *MemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+func seconds(f float64) time.Duration {
+	return time.Duration(f * float64(time.Second))
+}
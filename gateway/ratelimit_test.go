@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryRateLimitStoreRefill(t *testing.T) {
+	limit := RateLimit{Rate: 1, Burst: 2} // one token per second
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name          string
+		after         time.Duration // since start
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{"first request", 0, true, 1, 0},
+		{"burst used up", 0, true, 0, 0},
+		{"empty bucket", 0, false, 0, time.Second},
+		{"half a token", 500 * time.Millisecond, false, 0, 500 * time.Millisecond},
+		{"refilled one token", time.Second, true, 0, 0},
+		{"refill is capped at burst", time.Minute, true, 1, 0},
+		{"second token of the refill", time.Minute, true, 0, 0},
+	}
+
+	s := NewMemoryRateLimitStore()
+	for _, tt := range tests {
+		res := s.take("k", limit, start.Add(tt.after))
+		if res.Allowed != tt.wantAllowed || res.Remaining != tt.wantRemaining || res.RetryAfter != tt.wantRetry {
+			t.Errorf("%s: got allowed=%v remaining=%d retry=%s, want %v %d %s", tt.name,
+				res.Allowed, res.Remaining, res.RetryAfter, tt.wantAllowed, tt.wantRemaining, tt.wantRetry)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreKeysAreSeparate(t *testing.T) {
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Unix(1700000000, 0)
+
+	s := NewMemoryRateLimitStore()
+	if !s.take("a", limit, now).Allowed {
+		t.Fatal("first request for a was limited")
+	}
+	if s.take("a", limit, now).Allowed {
+		t.Error("second request for a was allowed")
+	}
+	if !s.take("b", limit, now).Allowed {
+		t.Error("b was limited by a's bucket")
+	}
+}
+
+func TestRateLimitMiddlewareKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := RateLimitConfig{Requests: 1, Period: time.Hour, Burst: 1}
+
+	tests := []struct {
+		name   string
+		second func(*http.Request) // changes to the repeated request
+		want   int
+	}{
+		{"same client", func(*http.Request) {}, http.StatusTooManyRequests},
+		{"forged X-Forwarded-For", func(r *http.Request) { r.Header.Set("X-Forwarded-For", "203.0.113.9") }, http.StatusTooManyRequests},
+		{"other client", func(r *http.Request) { r.RemoteAddr = "192.0.2.2:4000" }, http.StatusOK},
+		{"authenticated user", func(r *http.Request) { r.Header.Set("X-Test-User", "u1") }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.SetTrustedProxies(nil)
+			r.Use(func(c *gin.Context) {
+				if u := c.GetHeader("X-Test-User"); u != "" {
+					c.Set("userID", u)
+				}
+			})
+			r.Use(RateLimitMiddleware("books", cfg, NewMemoryRateLimitStore(), NewRateLimitStats()))
+			r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			first := httptest.NewRequest(http.MethodGet, "/", nil)
+			first.RemoteAddr = "192.0.2.1:4000"
+			r.ServeHTTP(httptest.NewRecorder(), first)
+
+			second := httptest.NewRequest(http.MethodGet, "/", nil)
+			second.RemoteAddr = "192.0.2.1:4000"
+			tt.second(second)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, second)
+			if w.Code != tt.want {
+				t.Errorf("second request: status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigManager owns the active gateway config and its Router. Reloads
+// build a complete new Router first and swap it in atomically, so requests
+// in flight finish on the old one and a bad config never replaces a good one.
+type ConfigManager struct {
+	path   string
+	shared *Shared
+	active atomic.Pointer[activeConfig]
+
+	mu            sync.Mutex // serializes reloads
+	lastAttempt   time.Time
+	lastError     string
+	failedVersion string // don't retry (and re-log) the same broken file
+}
+
+type activeConfig struct {
+	cfg      *GatewayConfig
+	router   *Router
+	version  string // content hash of the config file
+	loadedAt time.Time
+}
+
+// ConfigStatus is what the admin endpoint reports.
+type ConfigStatus struct {
+	Path        string    `json:"path"`
+	Version     string    `json:"version"`
+	LoadedAt    time.Time `json:"loaded_at"`
+	Routes      int       `json:"routes"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+func NewConfigManager(path string, shared *Shared) (*ConfigManager, error) {
+	m := &ConfigManager{path: path, shared: shared}
+	if _, err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload re-reads the config file. It reports whether a new config was
+// activated; an unchanged file is not an error.
+func (m *ConfigManager) Reload() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastAttempt = time.Now()
+	raw, err := os.ReadFile(m.path)
+	if err != nil {
+		m.lastError = err.Error()
+		return false, err
+	}
+	sum := sha256.Sum256(raw)
+	version := hex.EncodeToString(sum[:6])
+
+	current := m.active.Load()
+	if (current != nil && current.version == version) || version == m.failedVersion {
+		return false, nil
+	}
+
+	next, err := buildConfig(raw, version, m.shared)
+	if err != nil {
+		m.failedVersion = version
+		m.lastError = err.Error()
+		return false, err
+	}
+	m.failedVersion = ""
+	m.lastError = ""
+
+	m.shared.Responses.Configure(next.cfg.ResponseCache)
+	m.shared.Tokens.Configure(next.cfg.Auth.Cache)
+	if current != nil {
+		// responses cached under a route that now proxies elsewhere or
+		// caches differently must not be served by the new config
+		for _, rc := range current.cfg.Routes {
+			if nrc := next.cfg.route(rc.Name); nrc == nil || !reflect.DeepEqual(rc, *nrc) {
+				m.shared.Responses.InvalidateAllUsers(rc.Name, "/")
+			}
+		}
+	}
+	m.active.Store(next)
+	if current != nil {
+		current.router.Close()
+	}
+	return true, nil
+}
+
+func buildConfig(raw []byte, version string, shared *Shared) (*activeConfig, error) {
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	router, err := NewRouter(cfg, shared)
+	if err != nil {
+		return nil, err
+	}
+	return &activeConfig{cfg: cfg, router: router, version: version, loadedAt: time.Now()}, nil
+}
+
+// Watch polls the config file for changes. Polling rather than inotify
+// also catches the symlink swaps used by Kubernetes ConfigMap mounts.
+func (m *ConfigManager) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.reloadAndLog("file change")
+		}
+	}
+}
+
+func (m *ConfigManager) reloadAndLog(trigger string) {
+	changed, err := m.Reload()
+	switch {
+	case err != nil:
+		log.Printf("config reload (%s) failed, keeping version %s: %v", trigger, m.Config().version, err)
+	case changed:
+		a := m.Config()
+		log.Printf("config reloaded (%s): version %s, %d routes", trigger, a.version, len(a.cfg.Routes))
+	}
+}
+
+func (m *ConfigManager) Config() *activeConfig {
+	return m.active.Load()
+}
+
+// Handle routes a request with whichever Router is active right now.
+func (m *ConfigManager) Handle(c *gin.Context) {
+	m.active.Load().router.Handle(c)
+}
+
+func (m *ConfigManager) Status() ConfigStatus {
+	a := m.active.Load()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return ConfigStatus{
+		Path:        m.path,
+		Version:     a.version,
+		LoadedAt:    a.loadedAt,
+		Routes:      len(a.cfg.Routes),
+		LastAttempt: m.lastAttempt,
+		LastError:   m.lastError,
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reloadTestConfig = `
+upstreams:
+  books:
+    url: http://127.0.0.1:1
+routes:
+  - name: books
+    path_prefix: /books
+    upstream: books
+    cache: {enabled: true, ttl: 30s}
+  - name: authors
+    path_prefix: /authors
+    upstream: books
+    cache: {enabled: true, ttl: 30s}
+response_cache:
+  max_entries: 10
+`
+
+func TestReloadKeepsCaches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	write := func(cfg string) {
+		if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(reloadTestConfig)
+
+	shared := &Shared{
+		RateLimits:     NewMemoryRateLimitStore(),
+		RateLimitStats: NewRateLimitStats(),
+		Drains:         NewDrainSet(),
+		Streams:        NewStreamTracker(),
+		Responses:      NewResponseCache(ResponseCacheConfig{}),
+		Tokens:         NewIntrospectionCache(IntrospectionCacheConfig{}),
+	}
+	configs, err := NewConfigManager(path, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { configs.Config().router.Close() }()
+
+	cache := shared.Responses
+	for _, e := range []struct{ route, path string }{{"authors", "/authors"}, {"authors", "/authors/7"}, {"books", "/books"}} {
+		cache.put(&cachedResponse{key: e.route + "\x00\x00" + e.path, route: e.route, path: e.path, body: []byte("x")}, cache.generation())
+	}
+	shared.Tokens.put("token", &introspection{Active: true})
+
+	small := strings.Replace(reloadTestConfig, "max_entries: 10", "max_entries: 2", 1)
+	steps := []struct {
+		name        string
+		cfg         string
+		wantEntries int
+		wantTokens  int
+	}{
+		{"unrelated change keeps every entry",
+			strings.Replace(reloadTestConfig, "max_entries: 10", "max_entries: 10\n  max_bytes: 1048576", 1), 3, 1},
+		{"smaller limits evict the oldest", small, 2, 1},
+		{"changed route loses its entries, others keep theirs",
+			strings.Replace(small, "path_prefix: /books", "path_prefix: /volumes", 1), 1, 1},
+		{"removed route loses its entries",
+			strings.Replace(small, "  - name: authors\n    path_prefix: /authors\n    upstream: books\n    cache: {enabled: true, ttl: 30s}\n", "", 1), 0, 1},
+	}
+	for _, s := range steps {
+		write(s.cfg)
+		if changed, err := configs.Reload(); err != nil || !changed {
+			t.Fatalf("%s: reload changed=%v err=%v", s.name, changed, err)
+		}
+		if configs.Config().router.cache != cache || configs.Config().router.tokens != shared.Tokens {
+			t.Fatalf("%s: router got its own caches", s.name)
+		}
+		if got := cache.Len(); got != s.wantEntries {
+			t.Errorf("%s: %d cached responses, want %d", s.name, got, s.wantEntries)
+		}
+		if got := shared.Tokens.Len(); got != s.wantTokens {
+			t.Errorf("%s: %d cached introspections, want %d", s.name, got, s.wantTokens)
+		}
+	}
+}
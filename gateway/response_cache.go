@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var responseCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_response_cache_lookups_total",
+	Help: "Response cache lookups by route and result (hit, miss, revalidated, bypass).",
+}, []string{"route", "result"})
+
+// varyKeyHeaders are the request headers every cache key includes. A
+// response that varies on anything else (besides Authorization on
+// per-user routes) is not cached.
+var varyKeyHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language"}
+
+// ResponseCache is an LRU of upstream GET responses bounded by entry
+// count and total body size. Keys contain the route, the user for
+// authenticated routes, the path and query and the varyKeyHeaders.
+type ResponseCache struct {
+	cfg ResponseCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	bytes   int
+	gen     uint64 // bumped by every invalidation
+	lookups map[string]int64
+}
+
+type cachedResponse struct {
+	key     string
+	route   string
+	user    string // "" for responses shared by anonymous callers
+	path    string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func NewResponseCache(cfg ResponseCacheConfig) *ResponseCache {
+	return &ResponseCache{cfg: cfg, entries: map[string]*list.Element{}, lru: list.New(), lookups: map[string]int64{}}
+}
+
+func (rc *ResponseCache) count(route, result string) {
+	responseCacheLookups.WithLabelValues(route, result).Inc()
+	rc.mu.Lock()
+	rc.lookups[result]++
+	rc.mu.Unlock()
+}
+
+// ResponseCacheStats is what the admin API reports.
+type ResponseCacheStats struct {
+	Entries  int              `json:"entries"`
+	Bytes    int              `json:"bytes"`
+	MaxBytes int              `json:"max_bytes"`
+	Lookups  map[string]int64 `json:"lookups"`
+}
+
+func (rc *ResponseCache) Stats() ResponseCacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return ResponseCacheStats{Entries: rc.lru.Len(), Bytes: rc.bytes, MaxBytes: rc.cfg.MaxBytes, Lookups: maps.Clone(rc.lookups)}
+}
+
+func (rc *ResponseCache) get(key string) (*cachedResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	el, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	rc.lru.MoveToFront(el)
+	return el.Value.(*cachedResponse), true
+}
+
+// generation is read before a request goes upstream; put refuses the
+// response if an invalidation happened in between, since it may be stale.
+func (rc *ResponseCache) generation() uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.gen
+}
+
+func (rc *ResponseCache) put(e *cachedResponse, gen uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if gen != rc.gen || len(e.body) > rc.cfg.MaxObjectBytes {
+		return
+	}
+	if el, ok := rc.entries[e.key]; ok {
+		rc.remove(el)
+	}
+	rc.entries[e.key] = rc.lru.PushFront(e)
+	rc.bytes += len(e.body)
+	rc.evict()
+}
+
+func (rc *ResponseCache) evict() {
+	for rc.lru.Len() > rc.cfg.MaxEntries || rc.bytes > rc.cfg.MaxBytes {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// Configure applies the limits of a new config, evicting the least
+// recently used entries that no longer fit.
+func (rc *ResponseCache) Configure(cfg ResponseCacheConfig) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.cfg = cfg
+	rc.evict()
+}
+
+func (rc *ResponseCache) maxObjectBytes() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.cfg.MaxObjectBytes
+}
+
+func (rc *ResponseCache) remove(el *list.Element) {
+	e := rc.lru.Remove(el).(*cachedResponse)
+	delete(rc.entries, e.key)
+	rc.bytes -= len(e.body)
+}
+
+// Invalidate drops the route's entries at or below path that a write by
+// userID can have changed: the user's own and the shared ones. Other
+// users' entries stay.
+func (rc *ResponseCache) Invalidate(route, userID, path string) int {
+	return rc.invalidate(route, path, func(user string) bool { return user == userID || user == "" })
+}
+
+// InvalidateAllUsers drops the route's entries at or below path, whoever
+// they were cached for.
+func (rc *ResponseCache) InvalidateAllUsers(route, path string) int {
+	return rc.invalidate(route, path, func(string) bool { return true })
+}
+
+func (rc *ResponseCache) invalidate(route, path string, user func(string) bool) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.gen++
+	n := 0
+	for el := rc.lru.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*cachedResponse); e.route == route && user(e.user) && pathWithin(e.path, path) {
+			rc.remove(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// Purge empties the cache.
+func (rc *ResponseCache) Purge() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.gen++
+	rc.entries = map[string]*list.Element{}
+	rc.lru.Init()
+	rc.bytes = 0
+}
+
+// Len reports the number of cached responses.
+func (rc *ResponseCache) Len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.lru.Len()
+}
+
+// Bytes reports the total size of cached bodies.
+func (rc *ResponseCache) Bytes() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.bytes
+}
+
+// pathWithin reports whether path is prefix or below it.
+func pathWithin(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+type cacheCaptureKey struct{}
+
+// cacheCapture receives the upstream status and headers from the proxy's
+// ModifyResponse, before the gateway's own headers are mixed in.
+type cacheCapture struct {
+	status int
+	header http.Header
+}
+
+func captureUpstreamResponse(resp *http.Response) {
+	if cc, ok := resp.Request.Context().Value(cacheCaptureKey{}).(*cacheCapture); ok {
+		cc.status = resp.StatusCode
+		cc.header = resp.Header.Clone()
+	}
+}
+
+// CacheHandler wraps a route's proxy with the response cache. GET requests
+// are answered from fresh entries; stale ones are revalidated upstream
+// with their ETag or Last-Modified. Successful writes through the route
+// invalidate the written resource's collection (see writeScope) for the
+// writing user.
+// Responses carry X-Cache.
+func CacheHandler(route RouteConfig, cache *ResponseCache, proxy gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := c.Request
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodHead, http.MethodOptions:
+			proxy(c)
+			return
+		default:
+			proxy(c)
+			if c.Writer.Status() < http.StatusBadRequest {
+				cache.Invalidate(route.Name, c.GetString("userID"), writeScope(route, req.URL.Path))
+			}
+			return
+		}
+
+		reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+		userID := c.GetString("userID")
+		// without a user in the key, a credentialed response could leak
+		// to other callers
+		if reqCC.has("no-store") || (userID == "" && req.Header.Get("Authorization") != "") {
+			cache.count(route.Name, "bypass")
+			c.Header("X-Cache", "BYPASS")
+			proxy(c)
+			return
+		}
+
+		key := cacheKey(route.Name, userID, req)
+		clientETags := req.Header.Get("If-None-Match")
+		now := time.Now()
+		entry, found := cache.get(key)
+		if found && now.Before(entry.expires) && !reqCC.has("no-cache") && reqCC.maxAge(now.Sub(entry.stored)) {
+			cache.count(route.Name, "hit")
+			serveCached(c, entry, "HIT", now, clientETags)
+			return
+		}
+
+		// revalidate only on the client's behalf when it sent no
+		// conditions of its own; otherwise its conditions go upstream
+		revalidating := found && clientETags == "" && req.Header.Get("If-Modified-Since") == ""
+		if revalidating {
+			revalidating = setValidators(req.Header, entry.header)
+		}
+
+		gen := cache.generation()
+		capture := &cacheCapture{}
+		c.Request = req.WithContext(context.WithValue(req.Context(), cacheCaptureKey{}, capture))
+		w := &cacheWriter{ResponseWriter: c.Writer, revalidating: revalidating, limit: cache.maxObjectBytes()}
+		c.Writer = w
+		c.Header("X-Cache", "MISS")
+		proxy(c)
+		c.Writer = w.ResponseWriter
+
+		if w.notModified {
+			// upstream confirmed the entry: refresh its lifetime and serve it
+			refreshed := *entry
+			refreshed.header = entry.header.Clone()
+			for _, name := range []string{"Cache-Control", "Expires", "Date", "ETag", "Last-Modified"} {
+				if v := capture.header.Values(name); len(v) > 0 {
+					refreshed.header[name] = v
+				}
+			}
+			refreshed.stored = time.Now()
+			if ttl, ok := freshness(refreshed.header, route, userID != "", refreshed.stored); ok {
+				refreshed.expires = refreshed.stored.Add(ttl)
+				cache.put(&refreshed, gen)
+			}
+			cache.count(route.Name, "revalidated")
+			serveCached(c, &refreshed, "REVALIDATED", refreshed.stored, clientETags)
+			return
+		}
+		cache.count(route.Name, "miss")
+
+		if capture.status != http.StatusOK || w.tooBig || c.Writer.Status() != http.StatusOK {
+			return
+		}
+		if !cacheableVary(capture.header, userID != "") || capture.header.Get("Set-Cookie") != "" {
+			return
+		}
+		stored := time.Now()
+		ttl, ok := freshness(capture.header, route, userID != "", stored)
+		if !ok {
+			return
+		}
+		cache.put(&cachedResponse{
+			key:     key,
+			route:   route.Name,
+			user:    userID,
+			path:    req.URL.Path,
+			status:  capture.status,
+			header:  capture.header,
+			body:    bytes.Clone(w.body.Bytes()),
+			stored:  stored,
+			expires: stored.Add(ttl),
+		}, gen)
+	}
+}
+
+// InvalidateHandler evicts the writing user's cached responses of the
+// routes named in route.Invalidates after next served a successful write.
+func InvalidateHandler(route RouteConfig, cache *ResponseCache, prefixes map[string]string, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		next(c)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if c.Writer.Status() < http.StatusBadRequest {
+			for _, name := range route.Invalidates {
+				cache.Invalidate(name, c.GetString("userID"), prefixes[name])
+			}
+		}
+	}
+}
+
+// writeScope is what a write to path may have changed: its collection,
+// including derived views like /books/summary next to /books/42, but
+// nothing outside the route.
+func writeScope(route RouteConfig, path string) string {
+	parent := path[:strings.LastIndex(strings.TrimSuffix(path, "/"), "/")+1]
+	if !pathWithin(parent, route.PathPrefix) {
+		return route.PathPrefix
+	}
+	return parent
+}
+
+func cacheKey(route, userID string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(route)
+	b.WriteString("\x00")
+	b.WriteString(userID)
+	b.WriteString("\x00")
+	b.WriteString(req.URL.Path)
+	b.WriteString("?")
+	b.WriteString(req.URL.Query().Encode()) // sorted
+	for _, h := range varyKeyHeaders {
+		b.WriteString("\x00")
+		b.WriteString(req.Header.Get(h))
+	}
+	return b.String()
+}
+
+// serveCached writes e, or a 304 when it matches the client's If-None-Match.
+func serveCached(c *gin.Context, e *cachedResponse, result string, now time.Time, clientETags string) {
+	h := c.Writer.Header()
+	for name, vs := range e.header {
+		h[name] = vs
+	}
+	h.Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+	h.Set("X-Cache", result)
+
+	if etag := e.header.Get("ETag"); etag != "" && etagMatches(clientETags, etag) {
+		h.Del("Content-Length")
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.Writer.WriteHeader(e.status)
+	c.Writer.Write(e.body)
+	c.Abort()
+}
+
+// setValidators turns the entry's ETag / Last-Modified into conditional
+// request headers and reports whether there was any.
+func setValidators(req, stored http.Header) bool {
+	ok := false
+	if etag := stored.Get("ETag"); etag != "" {
+		req.Set("If-None-Match", etag)
+		ok = true
+	}
+	if lm := stored.Get("Last-Modified"); lm != "" {
+		req.Set("If-Modified-Since", lm)
+		ok = true
+	}
+	return ok
+}
+
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheableVary accepts responses that only vary on headers in the key.
+func cacheableVary(h http.Header, perUser bool) bool {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			switch {
+			case name == "" || slices.Contains(varyKeyHeaders, name):
+			case name == "Authorization" && perUser:
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// freshness decides whether a response may be stored and for how long.
+// no-cache responses are stored with no lifetime when they carry a
+// validator, so every use is revalidated.
+func freshness(h http.Header, route RouteConfig, perUser bool, now time.Time) (time.Duration, bool) {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if cc.has("no-store") || (cc.has("private") && !perUser) {
+		return 0, false
+	}
+	hasValidator := h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+	if cc.has("no-cache") {
+		return 0, hasValidator
+	}
+
+	ttl := route.Cache.TTL
+	if v, ok := cc["s-maxage"]; ok {
+		ttl = parseSeconds(v)
+	} else if v, ok := cc["max-age"]; ok {
+		ttl = parseSeconds(v)
+	} else if exp := h.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			return 0, false
+		}
+		ttl = t.Sub(now)
+	}
+	if ttl <= 0 {
+		return 0, hasValidator
+	}
+	return ttl, true
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(v string) cacheControl {
+	cc := cacheControl{}
+	for _, d := range strings.Split(v, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if name != "" {
+			cc[strings.ToLower(name)] = strings.Trim(val, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// maxAge applies a client's max-age limit to an entry of the given age.
+func (cc cacheControl) maxAge(age time.Duration) bool {
+	v, ok := cc["max-age"]
+	return !ok || age <= parseSeconds(v)
+}
+
+func parseSeconds(v string) time.Duration {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// cacheWriter copies the response body while it streams to the client,
+// up to limit. While revalidating, an upstream 304 is kept from the
+// client so the cached body can be served instead.
+type cacheWriter struct {
+	gin.ResponseWriter
+	revalidating bool
+	notModified  bool
+	body         bytes.Buffer
+	limit        int
+	tooBig       bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.revalidating && code == http.StatusNotModified {
+		w.notModified = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.notModified {
+		return len(b), nil
+	}
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	if w.notModified {
+		return len(s), nil
+	}
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *cacheWriter) Flush() {
+	if !w.notModified {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *cacheWriter) capture(b []byte) {
+	if w.tooBig {
+		return
+	}
+	if w.body.Len()+len(b) > w.limit {
+		w.tooBig = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(b)
+}
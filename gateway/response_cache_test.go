@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeUpstream stands in for a route's proxy and reports its response to
+// the cache like ModifyResponse does.
+func fakeUpstream(c *gin.Context) {
+	user := c.GetString("userID")
+	if c.Request.Method != http.MethodGet {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	h := http.Header{"Cache-Control": {"private, max-age=60"}, "Content-Type": {"text/plain"}}
+	captureUpstreamResponse(&http.Response{StatusCode: http.StatusOK, Header: h, Request: c.Request})
+	for name, vs := range h {
+		c.Writer.Header()[name] = vs
+	}
+	c.String(http.StatusOK, "books of %s at %s", user, c.Request.URL.Path)
+}
+
+func newCacheTestRouter(cache *ResponseCache) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	books := RouteConfig{Name: "books", PathPrefix: "/books", Cache: RouteCacheConfig{Enabled: true, TTL: time.Minute}}
+	sync := RouteConfig{Name: "sync", PathPrefix: "/sync", Invalidates: []string{"books"}}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if u := c.GetHeader("X-Test-User"); u != "" {
+			c.Set("userID", u)
+		}
+	})
+	r.Any("/books/*rest", CacheHandler(books, cache, fakeUpstream))
+	r.Any("/books", CacheHandler(books, cache, fakeUpstream))
+	r.Any("/sync", InvalidateHandler(sync, cache, map[string]string{"books": "/books"}, fakeUpstream))
+	return r
+}
+
+func TestResponseCacheUserIsolation(t *testing.T) {
+	cache := NewResponseCache(ResponseCacheConfig{MaxEntries: 100, MaxBytes: 1 << 20, MaxObjectBytes: 1 << 16})
+	r := newCacheTestRouter(cache)
+
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// the steps share one cache and build on each other
+	steps := []struct {
+		name      string
+		method    string
+		path      string
+		user      string
+		wantCache string // X-Cache, for GETs
+		wantBody  string
+	}{
+		{"alice fills her entry", http.MethodGet, "/books", "alice", "MISS", "books of alice at /books"},
+		{"alice hits it", http.MethodGet, "/books", "alice", "HIT", "books of alice at /books"},
+		{"bob never sees alice's entry", http.MethodGet, "/books", "bob", "MISS", "books of bob at /books"},
+		{"bob hits his own", http.MethodGet, "/books", "bob", "HIT", "books of bob at /books"},
+		{"alice caches her summary", http.MethodGet, "/books/summary", "alice", "MISS", "books of alice at /books/summary"},
+		{"bob caches his summary", http.MethodGet, "/books/summary", "bob", "MISS", "books of bob at /books/summary"},
+		{"alice updates a book", http.MethodPut, "/books/42", "alice", "", ""},
+		{"alice's list was evicted", http.MethodGet, "/books", "alice", "MISS", "books of alice at /books"},
+		{"alice's summary was evicted", http.MethodGet, "/books/summary", "alice", "MISS", "books of alice at /books/summary"},
+		{"bob's list survived", http.MethodGet, "/books", "bob", "HIT", "books of bob at /books"},
+		{"bob's summary survived", http.MethodGet, "/books/summary", "bob", "HIT", "books of bob at /books/summary"},
+		{"bob syncs", http.MethodPost, "/sync", "bob", "", ""},
+		{"bob's list was evicted by the sync", http.MethodGet, "/books", "bob", "MISS", "books of bob at /books"},
+		{"alice's list survived bob's sync", http.MethodGet, "/books", "alice", "HIT", "books of alice at /books"},
+	}
+	for _, s := range steps {
+		w := do(s.method, s.path, s.user)
+		if s.method != http.MethodGet {
+			if w.Code >= http.StatusBadRequest {
+				t.Fatalf("%s: status %d", s.name, w.Code)
+			}
+			continue
+		}
+		if got := w.Header().Get("X-Cache"); got != s.wantCache {
+			t.Errorf("%s: X-Cache %q, want %q", s.name, got, s.wantCache)
+		}
+		if got := w.Body.String(); got != s.wantBody {
+			t.Errorf("%s: body %q, want %q", s.name, got, s.wantBody)
+		}
+	}
+}
+
+func TestResponseCacheInvalidate(t *testing.T) {
+	entries := []struct{ route, user, path string }{
+		{"books", "alice", "/books"},
+		{"books", "alice", "/books/42"},
+		{"books", "bob", "/books"},
+		{"books", "", "/books/public"},
+		{"users", "alice", "/users/me"},
+	}
+	tests := []struct {
+		name     string
+		allUsers bool
+		route    string
+		user     string
+		path     string
+		want     int
+	}{
+		{"one user's collection and the shared entries", false, "books", "alice", "/books", 3},
+		{"one resource", false, "books", "alice", "/books/42", 1},
+		{"sibling paths are not below the prefix", false, "books", "alice", "/book", 0},
+		{"user without entries still clears shared ones", false, "books", "carol", "/books", 1},
+		{"other route", false, "users", "bob", "/", 0},
+		{"all users", true, "books", "", "/books", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewResponseCache(ResponseCacheConfig{MaxEntries: 100, MaxBytes: 1 << 20, MaxObjectBytes: 1 << 16})
+			for _, e := range entries {
+				key := e.route + "\x00" + e.user + "\x00" + e.path
+				cache.put(&cachedResponse{key: key, route: e.route, user: e.user, path: e.path, body: []byte("x")}, cache.generation())
+			}
+
+			var n int
+			if tt.allUsers {
+				n = cache.InvalidateAllUsers(tt.route, tt.path)
+			} else {
+				n = cache.Invalidate(tt.route, tt.user, tt.path)
+			}
+			if n != tt.want {
+				t.Errorf("removed %d, want %d", n, tt.want)
+			}
+			if cache.Len() != len(entries)-tt.want {
+				t.Errorf("%d entries left, want %d", cache.Len(), len(entries)-tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	base := httptest.NewRequest(http.MethodGet, "/books?b=2&a=1", nil)
+	key := cacheKey("books", "alice", base)
+
+	reordered := httptest.NewRequest(http.MethodGet, "/books?a=1&b=2", nil)
+	if cacheKey("books", "alice", reordered) != key {
+		t.Error("query parameter order changes the key")
+	}
+	if cacheKey("books", "bob", base) == key {
+		t.Error("users share a key")
+	}
+	if cacheKey("books", "", base) == key {
+		t.Error("anonymous callers share a key with a user")
+	}
+	lang := httptest.NewRequest(http.MethodGet, "/books?b=2&a=1", nil)
+	lang.Header.Set("Accept-Language", "de")
+	if cacheKey("books", "alice", lang) == key {
+		t.Error("Accept-Language is not part of the key")
+	}
+	// the separators keep user and path from sliding into each other
+	if cacheKey("books", "alice/x", httptest.NewRequest(http.MethodGet, "/y", nil)) ==
+		cacheKey("books", "alice", httptest.NewRequest(http.MethodGet, "/x/y", nil)) {
+		t.Error("user and path are ambiguous in the key")
+	}
+}
+
+func TestWriteScope(t *testing.T) {
+	route := RouteConfig{PathPrefix: "/books"}
+	tests := []struct{ path, want string }{
+		{"/books/42", "/books/"},
+		{"/books/42/notes/7", "/books/42/notes/"},
+		{"/books", "/books"},
+		{"/books/", "/books"},
+	}
+	for _, tt := range tests {
+		if got := writeScope(route, tt.path); got != tt.want {
+			t.Errorf("writeScope(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Router dispatches requests to the routes of a GatewayConfig by longest
+// path prefix. It is mounted as the engine's NoRoute handler so that fixed
+// endpoints such as /healthz keep precedence.
+type Router struct {
+	routes    []*route
+	upstreams map[string]*Upstream
+	transport *http.Transport // shared by every proxy in this route set
+	security  http.Header     // for requests that match no route
+	cache     *ResponseCache
+	tokens    *IntrospectionCache
+}
+
+type route struct {
+	cfg      RouteConfig
+	methods  map[string]bool
+	cors     *corsPolicy // nil when CORS is off for the route
+	security http.Header
+	handlers []gin.HandlerFunc // auth, scope checks and finally the proxy or composition
+}
+
+// Shared holds gateway state that must survive config reloads.
+type Shared struct {
+	RateLimits     RateLimitStore
+	RateLimitStats *RateLimitStats
+	Drains         *DrainSet // targets drained through the admin API
+	Streams        *StreamTracker
+	// the caches are resized by each config, not replaced, so a reload
+	// keeps their entries
+	Responses *ResponseCache
+	Tokens    *IntrospectionCache
+}
+
+func NewRouter(cfg *GatewayConfig, shared *Shared) (*Router, error) {
+	rt := &Router{
+		upstreams: make(map[string]*Upstream, len(cfg.Upstreams)),
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+		security:  securityHeaders(&cfg.SecurityHeaders),
+		cache:     shared.Responses,
+		tokens:    shared.Tokens,
+	}
+	var authMiddleware gin.HandlerFunc
+	if cfg.Auth.Enabled {
+		authMiddleware = JWTMiddleware(cfg.Auth, rt.tokens)
+	}
+	for name, uc := range cfg.Upstreams {
+		rt.upstreams[name] = NewUpstream(name, uc, rt.transport)
+		shared.Drains.Apply(rt.upstreams[name])
+	}
+	prefixes := make(map[string]string, len(cfg.Routes))
+	for _, rc := range cfg.Routes {
+		prefixes[rc.Name] = rc.PathPrefix
+	}
+	for _, rc := range cfg.Routes {
+		r := &route{cfg: rc, cors: newCORSPolicy(rc.CORS, rc.Methods), security: securityHeaders(rc.SecurityHeaders)}
+		if len(rc.Methods) > 0 {
+			r.methods = make(map[string]bool, len(rc.Methods))
+			for _, m := range rc.Methods {
+				r.methods[m] = true
+			}
+		}
+		if rc.Streaming.Enabled && rc.Streaming.QueryToken {
+			r.handlers = append(r.handlers, StreamTokenMiddleware())
+		}
+		if rc.Auth && authMiddleware != nil {
+			r.handlers = append(r.handlers, authMiddleware, ScopeMiddleware(rc.Scopes.Read, rc.Scopes.Write))
+		}
+		if rc.RateLimit.Requests > 0 {
+			r.handlers = append(r.handlers, RateLimitMiddleware(rc.Name, rc.RateLimit, shared.RateLimits, shared.RateLimitStats))
+		}
+		var handler gin.HandlerFunc
+		if len(rc.Compose) > 0 {
+			handler = ComposeHandler(rc, rt.upstreams, rt.transport, cfg.Auth.IdentitySecret)
+		} else {
+			proxy := ProxyHandler(rc, rt.upstreams[rc.Upstream], rt.transport, cfg.Auth.IdentitySecret)
+			handler = proxy
+			if rc.Cache.Enabled {
+				handler = CacheHandler(rc, rt.cache, handler)
+			}
+			if rc.Streaming.Enabled {
+				handler = StreamHandler(rc, shared.Streams, proxy, handler)
+			}
+			if len(rc.Invalidates) > 0 {
+				handler = InvalidateHandler(rc, rt.cache, prefixes, handler)
+			}
+		}
+		r.handlers = append(r.handlers, handler)
+		rt.routes = append(rt.routes, r)
+	}
+
+	// longest prefix first, so /users/me wins over /users
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return len(rt.routes[i].cfg.PathPrefix) > len(rt.routes[j].cfg.PathPrefix)
+	})
+
+	for _, u := range rt.upstreams {
+		u.StartHealthChecks()
+	}
+	return rt, nil
+}
+
+func (rt *Router) Handle(c *gin.Context) {
+	r := rt.match(c.Request.URL.Path)
+	if r == nil {
+		setHeaders(c.Writer.Header(), rt.security)
+		c.JSON(http.StatusNotFound, gin.H{"error": "no route for " + c.Request.URL.Path})
+		return
+	}
+	setHeaders(c.Writer.Header(), r.security)
+	if r.cors != nil && r.cors.handle(c) {
+		c.Set("route", r.cfg.Name)
+		return
+	}
+	if r.methods != nil && !r.methods[c.Request.Method] {
+		c.Header("Allow", strings.Join(r.cfg.Methods, ", "))
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "method not allowed"})
+		return
+	}
+
+	c.Set("route", r.cfg.Name)
+	c.Set("upstream", r.cfg.Upstream)
+	inFlight := httpInFlight.WithLabelValues(r.cfg.Name)
+	inFlight.Inc()
+	defer inFlight.Dec()
+	for _, h := range r.handlers {
+		h(c)
+		if c.IsAborted() {
+			return
+		}
+	}
+}
+
+func setHeaders(dst, src http.Header) {
+	for name, vs := range src {
+		dst[name] = vs
+	}
+}
+
+func (rt *Router) match(path string) *route {
+	for _, r := range rt.routes {
+		p := r.cfg.PathPrefix
+		if p == "/" || path == p || strings.HasPrefix(path, p+"/") {
+			return r
+		}
+	}
+	return nil
+}
+
+// Close releases the route set after it has been replaced: health checks
+// stop and idle upstream connections are dropped. Requests still running on
+// it are unaffected.
+func (rt *Router) Close() {
+	for _, u := range rt.upstreams {
+		u.Close()
+	}
+	rt.transport.CloseIdleConnections()
+}
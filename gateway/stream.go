@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	streamWebSocket = "websocket"
+	streamSSE       = "sse"
+)
+
+var (
+	streamsOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_streams_open",
+		Help: "Open WebSocket and SSE streams by route and kind.",
+	}, []string{"route", "kind"})
+	streamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_stream_duration_seconds",
+		Help:    "How long streams stayed open, by route and kind.",
+		Buckets: []float64{1, 10, 60, 300, 900, 1800, 3600, 4 * 3600},
+	}, []string{"route", "kind"})
+	streamsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_streams_rejected_total",
+		Help: "Streams refused because the user already had max_per_user open, by route.",
+	}, []string{"route"})
+	streamIdleCloses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_stream_idle_closes_total",
+		Help: "Streams closed by the gateway after idle_timeout without traffic, by route and kind.",
+	}, []string{"route", "kind"})
+)
+
+// streamKindKey marks a request admitted by StreamHandler; its value is
+// the stream kind.
+type streamKindKey struct{}
+
+// streamKind tells WebSocket handshakes and SSE requests apart from plain
+// requests, returning "" for the latter.
+func streamKind(req *http.Request) string {
+	if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") && headerHasToken(req.Header, "Connection", "upgrade") {
+		return streamWebSocket
+	}
+	if req.Method == http.MethodGet && strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		return streamSSE
+	}
+	return ""
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StreamTokenMiddleware moves ?access_token= of a stream handshake into
+// the Authorization header, for browsers that cannot set headers on
+// WebSocket and EventSource requests. The parameter is always removed so
+// it never reaches the upstream. It runs before authentication.
+func StreamTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if streamKind(c.Request) == "" {
+			return
+		}
+		q := c.Request.URL.Query()
+		token := q.Get("access_token")
+		if token == "" {
+			return
+		}
+		q.Del("access_token")
+		c.Request.URL.RawQuery = q.Encode()
+		if c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+}
+
+// StreamHandler sends the WebSocket and SSE requests of a streaming route
+// to stream, bypassing the response cache, and everything else to next.
+// Authentication and rate limiting already ran on the handshake. Each user
+// may hold route.Streaming.MaxPerUser streams on the route at a time.
+func StreamHandler(route RouteConfig, tracker *StreamTracker, stream, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kind := streamKind(c.Request)
+		if kind == "" {
+			next(c)
+			return
+		}
+		key := c.GetString("userID")
+		if key == "" {
+			key = c.ClientIP()
+		}
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		release, ok := tracker.acquire(route.Name, key, route.Streaming.MaxPerUser, cancel)
+		if !ok {
+			streamsRejected.WithLabelValues(route.Name).Inc()
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many open streams"})
+			return
+		}
+		defer release()
+		c.Request = c.Request.WithContext(context.WithValue(ctx, streamKindKey{}, kind))
+
+		open := streamsOpen.WithLabelValues(route.Name, kind)
+		open.Inc()
+		start := time.Now()
+		defer func() {
+			open.Dec()
+			streamDuration.WithLabelValues(route.Name, kind).Observe(time.Since(start).Seconds())
+			// ReverseProxy aborts with ErrAbortHandler when the copy fails
+			// midway, which for a stream is just the client going away
+			if err := recover(); err != nil && err != http.ErrAbortHandler {
+				panic(err)
+			}
+		}()
+		stream(c)
+	}
+}
+
+// StreamTracker counts open streams per route and user and can end them
+// all, which the gateway does on shutdown since http.Server.Shutdown does
+// not wait for hijacked connections and would wait out its timeout on SSE.
+type StreamTracker struct {
+	mu      sync.Mutex
+	open    map[string]int // "route\x00user" -> open streams
+	cancels map[uint64]context.CancelFunc
+	nextID  uint64
+}
+
+func NewStreamTracker() *StreamTracker {
+	return &StreamTracker{open: map[string]int{}, cancels: map[uint64]context.CancelFunc{}}
+}
+
+// acquire registers a stream unless the user already has max open on the
+// route; a negative max is unlimited.
+func (t *StreamTracker) acquire(route, user string, max int, cancel context.CancelFunc) (release func(), ok bool) {
+	key := route + "\x00" + user
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max >= 0 && t.open[key] >= max {
+		return nil, false
+	}
+	t.open[key]++
+	t.nextID++
+	id := t.nextID
+	t.cancels[id] = cancel
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.open[key]--; t.open[key] <= 0 {
+			delete(t.open, key)
+		}
+		delete(t.cancels, id)
+	}, true
+}
+
+// CloseAll ends every open stream.
+func (t *StreamTracker) CloseAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, cancel := range t.cancels {
+		cancel()
+	}
+}
+
+// Snapshot returns the number of open streams per route.
+func (t *StreamTracker) Snapshot() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := map[string]int{}
+	for key, n := range t.open {
+		route, _, _ := strings.Cut(key, "\x00")
+		out[route] += n
+	}
+	return out
+}
+
+// idleTimeoutBody closes an upstream stream after a period without
+// traffic. For an upgraded connection the body is the upstream connection
+// itself, so writes (client to upstream) count as traffic too.
+// An SSE stream closed this way ends like a normal response, so the
+// browser's EventSource reconnects.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	idle    atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, onIdle func()) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.idle.Store(true)
+		onIdle()
+		body.Close()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && b.idle.Load() {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Write(p []byte) (int, error) {
+	w, ok := b.ReadCloser.(io.Writer)
+	if !ok {
+		return 0, errors.New("stream body is not writable")
+	}
+	n, err := w.Write(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
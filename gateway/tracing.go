@@ -0,0 +1,9 @@
+package main
+
+import "go.opentelemetry.io/otel"
+
+const serviceName = "gateway"
+
+// tracer records the client spans of proxied attempts; server spans come
+// from platform/tracing.
+var tracer = otel.Tracer(serviceName)
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StrategyRoundRobin     = "round_robin"
+	StrategyLeastConn      = "least_conn"
+	StrategyConsistentHash = "consistent_hash"
+)
+
+var errNoHealthyTarget = errors.New("no healthy upstream target")
+
+// Upstream is a pool of interchangeable backend instances.
+type Upstream struct {
+	name    string
+	cfg     UpstreamConfig
+	targets []*Target
+	next    atomic.Uint64 // round-robin cursor
+	breaker *CircuitBreaker
+
+	client *http.Client // for active health checks
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Target is one instance of an upstream.
+type Target struct {
+	URL *url.URL
+
+	inflight atomic.Int64
+
+	// active health checking
+	healthy   atomic.Bool
+	hcSuccess int // consecutive results, only touched by the checker goroutine
+	hcFailure int
+
+	// passive ejection after consecutive failed requests
+	failures     atomic.Int32
+	ejectedUntil atomic.Int64 // unix nanos
+
+	// taken out of rotation through the admin API; requests in flight finish
+	draining atomic.Bool
+}
+
+func NewUpstream(name string, cfg UpstreamConfig, transport http.RoundTripper) *Upstream {
+	u := &Upstream{
+		name:    name,
+		cfg:     cfg,
+		breaker: NewCircuitBreaker(name, cfg.CircuitBreaker),
+		client:  &http.Client{Transport: transport, Timeout: cfg.HealthCheck.Timeout},
+		stop:    make(chan struct{}),
+	}
+	for _, raw := range cfg.Targets {
+		// already validated by GatewayConfig.Validate
+		parsed, _ := url.Parse(raw)
+		t := &Target{URL: parsed}
+		t.healthy.Store(true)
+		u.targets = append(u.targets, t)
+	}
+	return u
+}
+
+func (t *Target) available(now time.Time) bool {
+	return t.healthy.Load() && !t.draining.Load() && now.UnixNano() >= t.ejectedUntil.Load()
+}
+
+// TargetStatus is a target's state as shown by the admin API.
+type TargetStatus struct {
+	URL          string     `json:"url"`
+	Healthy      bool       `json:"healthy"`
+	Draining     bool       `json:"draining"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	InFlight     int64      `json:"in_flight"`
+	Failures     int32      `json:"consecutive_failures"`
+}
+
+func (t *Target) Status() TargetStatus {
+	st := TargetStatus{
+		URL:      t.URL.String(),
+		Healthy:  t.healthy.Load(),
+		Draining: t.draining.Load(),
+		InFlight: t.inflight.Load(),
+		Failures: t.failures.Load(),
+	}
+	if until := time.Unix(0, t.ejectedUntil.Load()); until.After(time.Now()) {
+		st.EjectedUntil = &until
+	}
+	return st
+}
+
+// Target returns the target with the given URL, or nil.
+func (u *Upstream) Target(rawURL string) *Target {
+	for _, t := range u.targets {
+		if t.URL.String() == rawURL {
+			return t
+		}
+	}
+	return nil
+}
+
+// DrainSet remembers targets drained through the admin API so that the
+// state survives config reloads.
+type DrainSet struct {
+	mu  sync.Mutex
+	set map[string]bool // "upstream target-url"
+}
+
+func NewDrainSet() *DrainSet {
+	return &DrainSet{set: map[string]bool{}}
+}
+
+func (d *DrainSet) Set(upstream string, t *Target, drain bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if drain {
+		d.set[upstream+" "+t.URL.String()] = true
+	} else {
+		delete(d.set, upstream+" "+t.URL.String())
+	}
+	t.draining.Store(drain)
+}
+
+// Apply marks the drained targets of a freshly built upstream.
+func (d *DrainSet) Apply(u *Upstream) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range u.targets {
+		t.draining.Store(d.set[u.name+" "+t.URL.String()])
+	}
+}
+
+// Pick chooses a target for one request. key is used by the consistent
+// hash strategy so the same user keeps landing on the same instance.
+// Targets in tried (earlier attempts of a retried request) are avoided
+// while others are available.
+func (u *Upstream) Pick(key string, tried []*Target) (*Target, error) {
+	now := time.Now()
+	candidates := make([]*Target, 0, len(u.targets))
+	for _, t := range u.targets {
+		if t.available(now) {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errNoHealthyTarget
+	}
+	if fresh := slices.DeleteFunc(slices.Clone(candidates), func(t *Target) bool {
+		return slices.Contains(tried, t)
+	}); len(fresh) > 0 {
+		candidates = fresh
+	}
+
+	switch u.cfg.Strategy {
+	case StrategyLeastConn:
+		// start at a rotating offset so ties don't all go to the first target
+		start := int(u.next.Add(1) % uint64(len(candidates)))
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			t := candidates[(start+i)%len(candidates)]
+			if t.inflight.Load() < best.inflight.Load() {
+				best = t
+			}
+		}
+		return best, nil
+	case StrategyConsistentHash:
+		if key != "" {
+			return rendezvous(candidates, key), nil
+		}
+	}
+	return candidates[u.next.Add(1)%uint64(len(candidates))], nil
+}
+
+// rendezvous implements highest-random-weight hashing: removing a target
+// only remaps the keys that were on it.
+func rendezvous(candidates []*Target, key string) *Target {
+	var best *Target
+	var bestScore uint64
+	for _, t := range candidates {
+		h := fnv.New64a()
+		io.WriteString(h, t.URL.String())
+		io.WriteString(h, "|")
+		io.WriteString(h, key)
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
+
+// Report feeds the outcome of a proxied request into passive ejection.
+func (u *Upstream) Report(t *Target, ok bool) {
+	if ok {
+		t.failures.Store(0)
+		return
+	}
+	ej := u.cfg.Ejection
+	if ej.MaxFailures <= 0 {
+		return
+	}
+	if t.failures.Add(1) >= int32(ej.MaxFailures) {
+		t.failures.Store(0)
+		t.ejectedUntil.Store(time.Now().Add(ej.Duration).UnixNano())
+		log.Printf("upstream %s: ejecting %s for %s after %d consecutive failures", u.name, t.URL, ej.Duration, ej.MaxFailures)
+	}
+}
+
+// StartHealthChecks probes every target's health check path until Close.
+func (u *Upstream) StartHealthChecks() {
+	hc := u.cfg.HealthCheck
+	if hc.Path == "" {
+		return
+	}
+	for _, t := range u.targets {
+		u.wg.Add(1)
+		go func(t *Target) {
+			defer u.wg.Done()
+			ticker := time.NewTicker(hc.Interval)
+			defer ticker.Stop()
+			for {
+				u.probe(t)
+				select {
+				case <-u.stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+}
+
+func (u *Upstream) probe(t *Target) {
+	hc := u.cfg.HealthCheck
+	ok := false
+
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(t.URL.String(), "/")+hc.Path, nil)
+	if err == nil {
+		if resp, err := u.client.Do(req); err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			ok = resp.StatusCode < 400
+		}
+	}
+
+	if ok {
+		t.hcSuccess, t.hcFailure = t.hcSuccess+1, 0
+		if !t.healthy.Load() && t.hcSuccess >= hc.HealthyThreshold {
+			t.healthy.Store(true)
+			log.Printf("upstream %s: %s is healthy", u.name, t.URL)
+		}
+	} else {
+		t.hcSuccess, t.hcFailure = 0, t.hcFailure+1
+		if t.healthy.Load() && t.hcFailure >= hc.UnhealthyThreshold {
+			t.healthy.Store(false)
+			log.Printf("upstream %s: %s is unhealthy", u.name, t.URL)
+		}
+	}
+}
+
+// UpstreamHealth summarizes whether an upstream can take requests.
+type UpstreamHealth struct {
+	Ready     bool   `json:"ready"`
+	Breaker   string `json:"breaker"`
+	Available int    `json:"available_targets"`
+	Targets   int    `json:"targets"`
+}
+
+// Health reports the upstream ready while its breaker isn't open and at
+// least one target is in rotation. Target health comes from the active
+// checks, so it is only as fresh as health_check.interval.
+func (u *Upstream) Health() UpstreamHealth {
+	h := UpstreamHealth{Breaker: u.breaker.State(), Targets: len(u.targets)}
+	now := time.Now()
+	for _, t := range u.targets {
+		if t.available(now) {
+			h.Available++
+		}
+	}
+	h.Ready = h.Available > 0 && h.Breaker != BreakerOpen
+	return h
+}
+
+func (u *Upstream) Close() {
+	close(u.stop)
+	u.wg.Wait()
+}
@@ -0,0 +1,59 @@
+// Package health serves the liveness and readiness probes of the BookLog
+// services.
+package health
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyTimeout bounds the database ping of a readiness check.
+const readyTimeout = 2 * time.Second
+
+// Pinger is the database a service needs to be ready; *sql.DB is one.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Handler serves the liveness and readiness probes. Liveness only says the
+// process is serving; readiness also needs the database and turns false as
+// soon as shutdown starts, so load balancers stop sending traffic while
+// requests in flight drain.
+type Handler struct {
+	db       Pinger
+	draining atomic.Bool
+}
+
+func NewHandler(db Pinger) *Handler {
+	return &Handler{db: db}
+}
+
+// Drain marks the service as shutting down. Callers keep serving for a
+// pre-stop delay afterwards, until load balancers have seen /readyz fail.
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Handler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handler) Readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+	if err := h.db.PingContext(ctx); err != nil {
+		log.Printf("readiness check: database ping failed: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "database": "unreachable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "database": "ok"})
+}
@@ -0,0 +1,234 @@
+// Package idempotency makes writes to the BookLog services safe to retry
+// with an Idempotency-Key header.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"platform/request"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyLockTimeout is how long a first request may run before a
+// retry with its key no longer waits on it, e.g. after a crash.
+const idempotencyLockTimeout = time.Minute
+
+// maxIdempotentBody caps the response body kept for replay. Larger
+// responses are not stored and the key is released instead.
+const maxIdempotentBody = 1 << 20
+
+// Handler makes POST, PUT, PATCH and DELETE requests safe to retry:
+// the response to a request with an Idempotency-Key header is stored for
+// TTL and replayed to retries with the same key. Reusing a key for a
+// different request is refused with 422; a retry while the first request
+// is still running gets 409. Server errors are not stored, so the request
+// can be retried for real.
+//
+// Requests are fingerprinted with an HMAC under a key derived from secret,
+// so stored fingerprints reveal nothing about the bodies, passwords
+// included.
+type Handler struct {
+	store Store
+	ttl   time.Duration
+	key   []byte
+}
+
+func NewHandler(store Store, ttl time.Duration, secret string) *Handler {
+	return &Handler{store: store, ttl: ttl, key: fingerprintKey(secret)}
+}
+
+// Record is a write made with an Idempotency-Key header and the response
+// to replay when it is retried. Status is 0 while the first request is
+// still running.
+type Record struct {
+	Scope       string // the user, or "ip:" and the client IP on anonymous endpoints
+	Key         string
+	Fingerprint string // HMAC-SHA256 of method, path and body
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Store keeps the records of a service.
+type Store interface {
+	// Reserve stores rec unless its key is taken, in which case it returns
+	// the stored record and false. Expired records and ones still running
+	// that were created before staleBefore are replaced.
+	Reserve(ctx context.Context, rec Record, staleBefore time.Time) (Record, bool, error)
+	// Complete stores the response of a reserved key.
+	Complete(ctx context.Context, scope, key string, status int, contentType string, body []byte) error
+	// Release forgets a reserved key so the request can be retried.
+	Release(ctx context.Context, scope, key string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Middleware scopes keys to the user when it runs after authentication;
+// on anonymous endpoints such as /register they are scoped to the client
+// IP, so one caller cannot collide with or replay another's key.
+func (h *Handler) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			key = ""
+		}
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is limited to 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		now := time.Now()
+		rec := Record{
+			Scope:       scope(c),
+			Key:         key,
+			Fingerprint: requestFingerprint(h.key, c.Request, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(h.ttl),
+		}
+		stored, reserved, err := h.store.Reserve(ctx, rec, now.Add(-idempotencyLockTimeout))
+		if err != nil {
+			log.Printf("idempotency: reserving key: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+			return
+		}
+		if !reserved {
+			replay(c, rec, stored)
+			return
+		}
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			// store with a fresh context: the client may be gone already
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			p := recover()
+			var err error
+			if status := w.Status(); p != nil || status >= http.StatusInternalServerError || w.truncated {
+				err = h.store.Release(ctx, rec.Scope, key)
+			} else {
+				err = h.store.Complete(ctx, rec.Scope, key, status, w.Header().Get("Content-Type"), w.body.Bytes())
+			}
+			if err != nil {
+				log.Printf("idempotency: storing response: %v", err)
+			}
+			if p != nil {
+				panic(p)
+			}
+		}()
+		c.Next()
+	}
+}
+
+func replay(c *gin.Context, rec, stored Record) {
+	switch {
+	case stored.Fingerprint != rec.Fingerprint:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was used for a different request"})
+	case stored.Status == 0:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is in progress"})
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		if stored.ContentType != "" {
+			c.Header("Content-Type", stored.ContentType)
+		}
+		c.Status(stored.Status)
+		c.Writer.Write(stored.Body)
+		c.Abort()
+	}
+}
+
+func scope(c *gin.Context) string {
+	if userID := request.UserID(c); userID != "" {
+		return userID
+	}
+	return "ip:" + request.ClientIP(c)
+}
+
+// fingerprintKey derives the fingerprint key from secret, so the key
+// differs from the one the secret signs tokens with.
+func fingerprintKey(secret string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(h, "booklog-idempotency")
+	return h.Sum(nil)
+}
+
+// requestFingerprint identifies a request by method, path and body.
+func requestFingerprint(key []byte, req *http.Request, body []byte) string {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, req.Method+" "+req.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response body up to
+// maxIdempotentBody and notes when it had to stop.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *recordingWriter) record(n int) bool {
+	if w.truncated || w.body.Len()+n > maxIdempotentBody {
+		w.truncated = true
+		w.body.Reset()
+		return false
+	}
+	return true
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.record(len(p)) {
+		w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	if w.record(len(s)) {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// RunCleanup periodically deletes expired keys.
+func (h *Handler) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n, err := h.store.DeleteExpired(ctx, now); err != nil {
+				log.Printf("idempotency: cleanup failed: %v", err)
+			} else if n > 0 {
+				log.Printf("idempotency: deleted %d expired keys", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memIdempotency is an in-memory Store.
+type memIdempotency struct {
+	mu   sync.Mutex
+	keys map[string]Record
+}
+
+func newMemIdempotency() *memIdempotency {
+	return &memIdempotency{keys: map[string]Record{}}
+}
+
+func (m *memIdempotency) Reserve(_ context.Context, rec Record, _ time.Time) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.keys[rec.Scope+"\x00"+rec.Key]; ok {
+		return stored, false, nil
+	}
+	m.keys[rec.Scope+"\x00"+rec.Key] = rec
+	return rec, true, nil
+}
+
+func (m *memIdempotency) Complete(_ context.Context, scope, key string, status int, contentType string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec := m.keys[scope+"\x00"+key]
+	rec.Status, rec.ContentType, rec.Body = status, contentType, body
+	m.keys[scope+"\x00"+key] = rec
+	return nil
+}
+
+func (m *memIdempotency) Release(_ context.Context, scope, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, scope+"\x00"+key)
+	return nil
+}
+
+func (m *memIdempotency) DeleteExpired(context.Context, time.Time) (int64, error) { return 0, nil }
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	type request struct {
+		key, user, ip, body string
+		wantStatus          int
+		wantBody            string // prefix
+		wantReplayed        bool
+	}
+	tests := []struct {
+		name     string
+		status   int  // returned by the handler
+		hold     bool // keep the first request running
+		huge     bool // respond with more than maxIdempotentBody
+		requests []request
+	}{
+		{"replays the stored response", http.StatusCreated, false, false, []request{
+			{"k1", "", "192.0.2.1", `{"email":"a@example.com"}`, http.StatusCreated, "call 1", false},
+			{"k1", "", "192.0.2.1", `{"email":"a@example.com"}`, http.StatusCreated, "call 1", true},
+		}},
+		{"refuses a reused key with 422", http.StatusCreated, false, false, []request{
+			{"k1", "", "192.0.2.1", `{"email":"a@example.com"}`, http.StatusCreated, "call 1", false},
+			{"k1", "", "192.0.2.1", `{"email":"b@example.com"}`, http.StatusUnprocessableEntity, "", false},
+		}},
+		{"answers 409 while the first request runs", http.StatusCreated, true, false, []request{
+			{"k1", "", "192.0.2.1", `{}`, http.StatusConflict, "", false},
+		}},
+		{"releases the key after a server error", http.StatusInternalServerError, false, false, []request{
+			{"k1", "", "192.0.2.1", `{}`, http.StatusInternalServerError, "call 1", false},
+			{"k1", "", "192.0.2.1", `{}`, http.StatusInternalServerError, "call 2", false},
+		}},
+		{"releases the key when the response is too large", http.StatusOK, false, true, []request{
+			{"k1", "", "192.0.2.1", `{}`, http.StatusOK, "call 1", false},
+			{"k1", "", "192.0.2.1", `{}`, http.StatusOK, "call 2", false},
+		}},
+		{"scopes anonymous keys to the client ip", http.StatusCreated, false, false, []request{
+			{"k1", "", "192.0.2.1", `{}`, http.StatusCreated, "call 1", false},
+			{"k1", "", "192.0.2.2", `{}`, http.StatusCreated, "call 2", false},
+			{"k1", "", "192.0.2.1", `{}`, http.StatusCreated, "call 1", true},
+		}},
+		{"scopes keys to the user when there is one", http.StatusCreated, false, false, []request{
+			{"k1", "alice", "192.0.2.1", `{}`, http.StatusCreated, "call 1", false},
+			{"k1", "bob", "192.0.2.1", `{}`, http.StatusCreated, "call 2", false},
+			{"k1", "", "192.0.2.1", `{}`, http.StatusCreated, "call 3", false},
+			{"k1", "alice", "192.0.2.2", `{}`, http.StatusCreated, "call 1", true},
+		}},
+		{"passes requests without a key through", http.StatusCreated, false, false, []request{
+			{"", "", "192.0.2.1", `{}`, http.StatusCreated, "call 1", false},
+			{"", "", "192.0.2.1", `{}`, http.StatusCreated, "call 2", false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemIdempotency()
+			calls := 0
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				c.Set("client_ip", c.GetHeader("X-Test-IP"))
+				if user := c.GetHeader("X-Test-User"); user != "" {
+					c.Set("userID", user)
+				}
+			})
+			r.POST("/register", NewHandler(repo, time.Hour, "s3cret").Middleware(), func(c *gin.Context) {
+				calls++
+				body := "call " + strconv.Itoa(calls)
+				if tt.huge {
+					body += strings.Repeat(".", maxIdempotentBody)
+				}
+				c.String(tt.status, body)
+			})
+
+			if tt.hold {
+				rec := Record{Scope: "ip:192.0.2.1", Key: "k1",
+					Fingerprint: requestFingerprint(fingerprintKey("s3cret"), httptest.NewRequest(http.MethodPost, "/register", nil), []byte(`{}`))}
+				repo.Reserve(context.Background(), rec, time.Time{})
+			}
+			for i, req := range tt.requests {
+				hr := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(req.body))
+				hr.Header.Set("X-Test-IP", req.ip)
+				hr.Header.Set("X-Test-User", req.user)
+				if req.key != "" {
+					hr.Header.Set("Idempotency-Key", req.key)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, hr)
+
+				if w.Code != req.wantStatus {
+					t.Fatalf("request %d: status %d, want %d (%s)", i, w.Code, req.wantStatus, w.Body)
+				}
+				if !strings.HasPrefix(w.Body.String(), req.wantBody) {
+					t.Errorf("request %d: body %.20q, want %q", i, w.Body, req.wantBody)
+				}
+				if got := w.Header().Get("Idempotent-Replayed") == "true"; got != req.wantReplayed {
+					t.Errorf("request %d: replayed %v, want %v", i, got, req.wantReplayed)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/register", nil)
+	body := []byte(`{"password":"hunter2"}`)
+	fp := requestFingerprint([]byte("s3cret"), req, body)
+
+	if requestFingerprint([]byte("other"), req, body) == fp {
+		t.Error("fingerprint does not depend on the key")
+	}
+	if requestFingerprint(fingerprintKey("s3cret"), req, body) == fp {
+		t.Error("fingerprint key is the secret itself")
+	}
+	if requestFingerprint([]byte("s3cret"), httptest.NewRequest(http.MethodPost, "/login", nil), body) == fp {
+		t.Error("fingerprint does not depend on the path")
+	}
+	if requestFingerprint([]byte("s3cret"), req, []byte(`{"password":"hunter3"}`)) == fp {
+		t.Error("fingerprint does not depend on the body")
+	}
+}
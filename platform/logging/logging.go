@@ -0,0 +1,117 @@
+// Package logging sets up structured, redacted slog output and the access
+// log for the BookLog gateway and services.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are matched case-insensitively against attribute keys
+// (including header names and query parameters); a key containing any of
+// them has its value replaced.
+var sensitiveKeys = []string{"authorization", "password", "passwd", "secret", "token", "cookie", "api_key", "api-key", "apikey", "signature", "totp", "code"}
+
+// credential patterns that can end up inside free-form messages
+var (
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`)
+	jwtPattern    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	patPattern    = regexp.MustCompile(`\bblp_[A-Za-z0-9_-]+`)
+)
+
+// Setup installs a slog default logger for the named service. LOG_FORMAT selects
+// json (the default) or text, LOG_LEVEL debug, info (the default), warn or
+// error. Output of the standard log package goes through the same handler,
+// so existing log.Printf calls are structured and redacted too.
+func Setup(service string) error {
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q", v)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var h slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "json":
+		h = slog.NewJSONHandler(os.Stdout, opts)
+	case "text":
+		h = slog.NewTextHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q", format)
+	}
+	slog.SetDefault(slog.New(h).With("service", service))
+	return nil
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if sensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, RedactString(a.Value.String()))
+	case slog.KindAny:
+		// errors and Stringers often embed request URLs or upstream
+		// responses; they are logged as their redacted text
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, RedactString(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, RedactString(v.String()))
+		}
+	}
+	return a
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactString masks bearer credentials, JWTs and personal access tokens.
+func RedactString(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	return patPattern.ReplaceAllString(s, redacted)
+}
+
+// RedactQuery returns the raw query with sensitive parameter values masked.
+func RedactQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	out := url.Values{}
+	for k, vs := range q {
+		if sensitive(k) {
+			out[k] = []string{redacted}
+			continue
+		}
+		out[k] = vs
+	}
+	s, _ := url.QueryUnescape(out.Encode())
+	return s
+}
+
+// Headers logs request headers as a group; sensitive ones are masked by
+// the handler like any other attribute.
+func Headers(h http.Header) slog.Attr {
+	attrs := make([]any, 0, len(h))
+	for k, vs := range h {
+		attrs = append(attrs, slog.String(k, strings.Join(vs, ", ")))
+	}
+	return slog.Group("headers", attrs...)
+}
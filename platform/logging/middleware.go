@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"platform/request"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware writes one structured access log line per request. Server
+// errors are logged at error level and client errors at warn; request
+// headers are included at debug level. Credentials in the query string and
+// headers are redacted by the handler installed by Setup.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		req := c.Request
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		ctx := req.Context()
+		if !slog.Default().Enabled(ctx, level) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", request.ID(c)),
+			slog.String("method", req.Method),
+			slog.String("route", request.Route(c)),
+			slog.String("path", req.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", request.ClientIP(c)),
+		}
+		if upstream := c.GetString("upstream"); upstream != "" {
+			attrs = append(attrs, slog.String("upstream", upstream))
+		}
+		if q := RedactQuery(req.URL.Query()); q != "" {
+			attrs = append(attrs, slog.String("query", q))
+		}
+		if userID := request.UserID(c); userID != "" {
+			attrs = append(attrs, slog.String("user_id", userID))
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		if slog.Default().Enabled(ctx, slog.LevelDebug) {
+			attrs = append(attrs, Headers(req.Header))
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	}
+}
+
+// Recovery turns panics into a JSON 500 and logs them with the request ID
+// and stack instead of gin's plain-text dump.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		slog.ErrorContext(c.Request.Context(), "panic recovered",
+			"request_id", request.ID(c),
+			"error", err,
+			"stack", string(debug.Stack()),
+		)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	})
+}
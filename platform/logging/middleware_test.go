@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type userID [2]byte
+
+func (u userID) String() string { return "u-" + string(u[:]) }
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: redact})))
+
+	tests := []struct {
+		name    string
+		path    string
+		set     map[string]any // stored on the context by earlier middleware
+		want    map[string]any
+		wantLvl string
+	}{
+		{"service route", "/books/42?token=abc&page=2", map[string]any{"userID": "u1"},
+			map[string]any{"route": "/books/:id", "user_id": "u1", "query": "page=2&token=[REDACTED]", "client_ip": "192.0.2.1"}, "INFO"},
+		{"user-service identity", "/books/42", map[string]any{"user_id": userID{'4', '2'}, "client_ip": "203.0.113.9"},
+			map[string]any{"user_id": "u-42", "client_ip": "203.0.113.9"}, "INFO"},
+		{"gateway route", "/books/42", map[string]any{"route": "books", "upstream": "book-service"},
+			map[string]any{"route": "books", "upstream": "book-service"}, "INFO"},
+		{"unmatched", "/nope", nil, map[string]any{"route": "unmatched", "status": float64(404)}, "WARN"},
+		{"panic", "/panic", nil, map[string]any{"route": "/panic", "status": float64(500)}, "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				for k, v := range tt.set {
+					c.Set(k, v)
+				}
+			}, Middleware(), Recovery())
+			r.GET("/books/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+			r.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			var line map[string]any
+			for _, l := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+				if err := json.Unmarshal(l, &line); err == nil && line["msg"] == "request" {
+					break
+				}
+			}
+			if line["msg"] != "request" {
+				t.Fatalf("no access log line in %q", buf.String())
+			}
+			if line["level"] != tt.wantLvl {
+				t.Errorf("level %v, want %s", line["level"], tt.wantLvl)
+			}
+			for k, want := range tt.want {
+				if line[k] != want {
+					t.Errorf("%s = %v, want %v", k, line[k], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// Package metrics holds the Prometheus request metrics shared by the
+// BookLog services.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Requests handled by route template, method and status.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Request latency by route template, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	httpInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Requests currently being handled, by route template.",
+	}, []string{"route"})
+)
+
+// Middleware records request metrics labelled with the gin route
+// template (/books/:id, not the raw path). Unknown paths share one label.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		inFlight := httpInFlight.WithLabelValues(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		c.Next()
+
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,87 @@
+// Package request assigns request IDs and reads what the gateway and the
+// services store on a gin.Context, for the shared logging and tracing
+// middleware.
+package request
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IDMiddleware makes sure every request has an X-Request-ID. A well-formed
+// ID from the caller is kept so logs can be correlated across the gateway
+// and the services; the ID is echoed in the response and forwarded with
+// the request.
+func IDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !validID(id) {
+			id = newID()
+		}
+		c.Set("requestID", id)
+		c.Request.Header.Set("X-Request-ID", id)
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ID returns the request ID set by IDMiddleware.
+func ID(c *gin.Context) string {
+	return c.GetString("requestID")
+}
+
+// UserID returns the authenticated caller, or "". The gateway and
+// book-service store it as a string under userID, user-service as a
+// uuid.UUID under user_id.
+func UserID(c *gin.Context) string {
+	if id := c.GetString("userID"); id != "" {
+		return id
+	}
+	if id, ok := c.Get("user_id"); ok {
+		return fmt.Sprint(id)
+	}
+	return ""
+}
+
+// ClientIP returns the verified client IP a service stored under client_ip,
+// falling back to gin's view of the connection.
+func ClientIP(c *gin.Context) string {
+	if ip := c.GetString("client_ip"); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
+
+// Route names the request for logs, spans and metrics: the gateway route
+// set by its router, else the gin path template. Unknown paths share
+// "unmatched", which keeps cardinality bounded.
+func Route(c *gin.Context) string {
+	if route := c.GetString("route"); route != "" {
+		return route
+	}
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return "unmatched"
+}
@@ -0,0 +1,95 @@
+// Package tracing sets up OpenTelemetry tracing for the BookLog gateway
+// and services.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"platform/request"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup installs the global tracer provider for the named service.
+// OTEL_TRACES_EXPORTER selects otlp (configured by the standard
+// OTEL_EXPORTER_OTLP_* variables), console for local runs, or none (the
+// default). traceparent headers are propagated either way, so a process
+// without an exporter doesn't break traces started elsewhere.
+func Setup(ctx context.Context, service string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch kind := os.Getenv("OTEL_TRACES_EXPORTER"); kind {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "console", "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Middleware starts a server span for each request, continuing the trace
+// of an incoming traceparent header. Spans are named after the route once
+// it is known; see request.Route.
+func Middleware(service string) gin.HandlerFunc {
+	tracer := otel.Tracer(service)
+
+	return func(c *gin.Context) {
+		req := c.Request
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer.Start(ctx, req.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLPath(req.URL.Path),
+			semconv.ClientAddress(request.ClientIP(c)),
+			semconv.UserAgentOriginal(req.UserAgent()),
+			attribute.String("request.id", request.ID(c)),
+		))
+		defer span.End()
+
+		c.Request = req.WithContext(ctx)
+		c.Next()
+
+		route := request.Route(c)
+		status := c.Writer.Status()
+		span.SetName(req.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+		if userID := request.UserID(c); userID != "" {
+			span.SetAttributes(attribute.String("enduser.id", userID))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
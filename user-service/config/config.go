@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -15,6 +17,37 @@ type Config struct {
 	DBName     string
 	DBSSLMode  string
 	JwtSecret  string
+
+	// AuthMode is "jwt" (verify the bearer token) or "gateway" (trust the
+	// identity headers set by the gateway, signed with IdentitySecret)
+	AuthMode       string
+	IdentitySecret string
+
+	BookServiceURL string
+	ServiceToken   string // shared secret for service-to-service calls
+	ExportDir      string
+	ExportLinkTTL  time.Duration
+
+	AppBaseURL   string // public URL used in links sent by email
+	Mailer       string // smtp, file or log
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	LoginAttemptStore  string // postgres or memory
+	LoginFreeAttempts  int
+	LoginLockoutBase   time.Duration
+	LoginLockoutMax    time.Duration
+	LoginFailureWindow time.Duration
+
+	TOTPIssuer string // shown in authenticator apps
+
+	PreStopDelay    time.Duration // how long /readyz fails before the listener closes on SIGTERM
+	ShutdownTimeout time.Duration // how long requests in flight get to finish on SIGTERM
+	IdempotencyTTL  time.Duration // how long responses to Idempotency-Key requests are replayed
 }
 
 func LoadConfig() (*Config, error) {
@@ -28,11 +61,60 @@ func LoadConfig() (*Config, error) {
 		DBName:     getEnv("DB_NAME", "booklog"),
 		DBSSLMode:  getEnv("DB_SSLMODE", "disable"),
 		JwtSecret:  getEnv("JWT_SECRET", ""), // no default, must set in env
+
+		AuthMode:       getEnv("AUTH_MODE", "jwt"),
+		IdentitySecret: getEnv("GATEWAY_IDENTITY_SECRET", ""),
+
+		BookServiceURL: getEnv("BOOK_SERVICE_URL", "http://book-service:8081"),
+		ServiceToken:   getEnv("SERVICE_TOKEN", ""),
+		ExportDir:      getEnv("EXPORT_DIR", "exports"),
+
+		AppBaseURL:   getEnv("APP_BASE_URL", "http://localhost:8000"),
+		Mailer:       getEnv("MAILER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "BookLog <no-reply@booklog.local>"),
+		MailDir:      getEnv("MAIL_DIR", "mail"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		LoginAttemptStore: getEnv("LOGIN_ATTEMPT_STORE", "postgres"),
+
+		TOTPIssuer: getEnv("TOTP_ISSUER", "BookLog"),
 	}
 
 	if cfg.JwtSecret == "" {
 		return nil, fmt.Errorf("JWT_SECRET environment variable is required")
 	}
+	if cfg.AuthMode != "jwt" && cfg.AuthMode != "gateway" {
+		return nil, fmt.Errorf("invalid AUTH_MODE %q: must be jwt or gateway", cfg.AuthMode)
+	}
+
+	var err error
+	if cfg.ExportLinkTTL, err = getDuration("EXPORT_LINK_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.LoginFreeAttempts, err = getInt("LOGIN_FREE_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if cfg.LoginLockoutBase, err = getDuration("LOGIN_LOCKOUT_BASE", 2*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.LoginLockoutMax, err = getDuration("LOGIN_LOCKOUT_MAX", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.LoginFailureWindow, err = getDuration("LOGIN_FAILURE_WINDOW", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.PreStopDelay, err = getDuration("PRESTOP_DELAY", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ShutdownTimeout, err = getDuration("SHUTDOWN_TIMEOUT", 15*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.IdempotencyTTL, err = getDuration("IDEMPOTENCY_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
 
 	log.Println("✅ Configuration loaded successfully")
 	return cfg, nil
@@ -45,3 +127,27 @@ func getEnv(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+func getDuration(key string, defaultVal time.Duration) (time.Duration, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultVal, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+func getInt(key string, defaultVal int) (int, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultVal, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
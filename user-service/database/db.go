@@ -1,12 +1,17 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"log"
 	"userService/config"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq" // Postgres driver
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func Connect(cfg *config.Config) (*sql.DB, error) {
@@ -15,7 +20,19 @@ func Connect(cfg *config.Config) (*sql.DB, error) {
 		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName, cfg.DBSSLMode,
 	)
 
-	db, err := sql.Open("postgres", connStr)
+	// query spans are only recorded inside a traced request, so background
+	// jobs and startup don't produce orphan traces
+	db, err := otelsql.Open("postgres", connStr,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
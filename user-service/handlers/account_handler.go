@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"userService/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	accountService *services.AccountService
+}
+
+func NewAccountHandler(accSrv *services.AccountService) *AccountHandler {
+	return &AccountHandler{accountService: accSrv}
+}
+
+func (h AccountHandler) VerifyEmail(c *gin.Context) {
+	var body struct {
+		Token string `json:"token"`
+	}
+
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	if err := h.accountService.VerifyEmail(c.Request.Context(), body.Token); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+func (h AccountHandler) ResendVerification(c *gin.Context) {
+	var body struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.BindJSON(&body); err != nil || body.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	h.accountService.ResendVerification(c.Request.Context(), body.Email)
+
+	// same answer whether or not the account exists or is verified
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the account needs verification, a new link has been sent"})
+}
+
+func (h AccountHandler) ForgotPassword(c *gin.Context) {
+	var body struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.BindJSON(&body); err != nil || body.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	h.accountService.ForgotPassword(c.Request.Context(), body.Email)
+
+	// same answer whether or not the account exists
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the account exists, a reset link has been sent"})
+}
+
+func (h AccountHandler) ResetPassword(c *gin.Context) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	if err := h.accountService.ResetPassword(c.Request.Context(), body.Token, body.Password); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password updated"})
+}
+
+func respondAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidToken), errors.Is(err, services.ErrWeakPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("account request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
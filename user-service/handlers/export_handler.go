@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"userService/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ExportHandler struct {
+	exportService *services.ExportService
+}
+
+func NewExportHandler(expSrv *services.ExportService) *ExportHandler {
+	return &ExportHandler{exportService: expSrv}
+}
+
+func (h ExportHandler) StartExport(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	job, err := h.exportService.Start(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":         job.ID,
+		"status":     job.Status,
+		"status_url": "/users/me/export/" + job.ID.String(),
+	})
+}
+
+func (h ExportHandler) GetExport(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid export id"})
+		return
+	}
+
+	job, err := h.exportService.Get(userID, jobID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{"export": job}
+	if url, err := h.exportService.DownloadURL(job); err == nil {
+		resp["download_url"] = url
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h ExportHandler) Download(c *gin.Context) {
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	path, err := h.exportService.OpenDownload(jobID, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrExportLinkInvalid) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.FileAttachment(path, "booklog-export.zip")
+}
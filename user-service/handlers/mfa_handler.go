@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"userService/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type MFAHandler struct {
+	mfaService *services.MFAService
+}
+
+func NewMFAHandler(mfaSrv *services.MFAService) *MFAHandler {
+	return &MFAHandler{mfaService: mfaSrv}
+}
+
+// LoginMFA is the second login step for accounts with 2FA enabled.
+func (h MFAHandler) LoginMFA(c *gin.Context) {
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	result, err := h.mfaService.CompleteLogin(c.Request.Context(), body.MFAToken, body.Code, c.GetString("client_ip"))
+	respondLogin(c, result, err)
+}
+
+func (h MFAHandler) Enroll(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	enrollment, err := h.mfaService.Enroll(c.Request.Context(), userID)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h MFAHandler) Confirm(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	codes, err := h.mfaService.Confirm(c.Request.Context(), userID, body.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+func (h MFAHandler) Disable(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	if err := h.mfaService.Disable(c.Request.Context(), userID, body.Password); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrMFANotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("2fa request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
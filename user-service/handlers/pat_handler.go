@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"userService/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type PATHandler struct {
+	patService *services.PATService
+}
+
+func NewPATHandler(patSrv *services.PATService) *PATHandler {
+	return &PATHandler{patService: patSrv}
+}
+
+func (h PATHandler) Create(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var body struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid data"})
+		return
+	}
+
+	plain, token, err := h.patService.Create(c.Request.Context(), userID, body.Name, body.Scopes, body.ExpiresAt)
+	if err != nil {
+		respondPATError(c, err)
+		return
+	}
+
+	// the only time the plaintext token is ever returned
+	c.JSON(http.StatusCreated, gin.H{
+		"token":   plain,
+		"details": token,
+	})
+}
+
+func (h PATHandler) List(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	tokens, err := h.patService.List(c.Request.Context(), userID)
+	if err != nil {
+		respondPATError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h PATHandler) Revoke(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+
+	if err := h.patService.Revoke(c.Request.Context(), userID, id); err != nil {
+		respondPATError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Introspect is the RFC 7662 style endpoint the gateway uses to resolve
+// personal access tokens. It is only reachable with the service token.
+func (h PATHandler) Introspect(c *gin.Context) {
+	result, err := h.patService.Introspect(c.Request.Context(), c.PostForm("token"))
+	if err != nil {
+		log.Printf("token introspection failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "introspection failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func respondPATError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidScopes),
+		errors.Is(err, services.ErrTokenName),
+		errors.Is(err, services.ErrTokenExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		log.Printf("token request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
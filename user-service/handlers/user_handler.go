@@ -1,14 +1,20 @@
 package handlers
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"userService/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type UserHandler struct {
-	userService *services.UserService
+	userService    *services.UserService
+	accountService *services.AccountService
 }
 
 type UserRegisterDto struct {
@@ -17,8 +23,8 @@ type UserRegisterDto struct {
 	Password  string `json:"password"`
 }
 
-func NewUserHandler(usrSrv *services.UserService) *UserHandler {
-	return &UserHandler{userService: usrSrv}
+func NewUserHandler(usrSrv *services.UserService, accSrv *services.AccountService) *UserHandler {
+	return &UserHandler{userService: usrSrv, accountService: accSrv}
 }
 
 func (h UserHandler) Register(c *gin.Context) {
@@ -30,12 +36,18 @@ func (h UserHandler) Register(c *gin.Context) {
 		return
 	}
 
-	err := h.userService.Register(body.FulllName, body.Email, body.Password)
+	user, err := h.userService.Register(c.Request.Context(), body.FulllName, body.Email, body.Password)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// the account exists either way; a new link is available from
+	// POST /verify-email/resend
+	if err := h.accountService.SendVerification(c.Request.Context(), user); err != nil {
+		log.Printf("failed to send verification email to %s: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "user created"})
 }
 
@@ -51,15 +63,52 @@ func (h UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	userToken, exp, err := h.userService.Login(body.Email, body.Password)
+	result, err := h.userService.Login(c.Request.Context(), body.Email, body.Password, c.GetString("client_ip"))
+	respondLogin(c, result, err)
+}
 
+// Me returns the caller's profile.
+func (h UserHandler) Me(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	user, err := h.userService.GetProfile(c.Request.Context(), userID)
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("profile request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// respondLogin writes the outcome of either login step.
+func respondLogin(c *gin.Context, result *services.LoginResult, err error) {
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
+	if result.MFARequired {
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    result.MFAToken,
+			"expires_at":   result.ExpiresAt,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": userToken,
-		"expires_at":   exp,
+		"access_token": result.AccessToken,
+		"expires_at":   result.ExpiresAt,
 	})
 }
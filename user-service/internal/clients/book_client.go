@@ -0,0 +1,70 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Book mirrors the JSON representation served by book-service.
+type Book struct {
+	ID          uint       `json:"id"`
+	Title       string     `json:"title"`
+	Author      string     `json:"author"`
+	Description string     `json:"description"`
+	Year        int        `json:"year"`
+	UserID      uuid.UUID  `json:"UserID"`
+	CreatedAt   time.Time  `json:"CreatedAt"`
+	UpdatedAt   time.Time  `json:"UpdatedAt"`
+	DeletedAt   *time.Time `json:"DeletedAt"`
+}
+
+// BookClient talks to book-service's internal API. Requests are
+// authenticated with the shared service token rather than an end-user JWT.
+type BookClient struct {
+	baseURL      string
+	serviceToken string
+	httpClient   *http.Client
+}
+
+func NewBookClient(baseURL, serviceToken string) *BookClient {
+	return &BookClient{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		serviceToken: serviceToken,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *BookClient) GetUserBooks(ctx context.Context, userID uuid.UUID) ([]Book, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/internal/users/"+userID.String()+"/books", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Service-Token", c.serviceToken)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("book-service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var books []Book
+	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
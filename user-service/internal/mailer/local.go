@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var errInvalidHeader = errors.New("mailer: header contains line break")
+
+// linkToken matches the token parameter of verification and reset links.
+var linkToken = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+// LogMailer writes messages to the standard logger. Intended for local
+// development where no mail server is available. Link tokens are masked,
+// since logs are shipped and kept; use the file driver to follow links.
+type LogMailer struct {
+	from string
+}
+
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	log.Printf("📧 mail from=%s to=%s subject=%q\n%s", m.from, msg.To, msg.Subject,
+		linkToken.ReplaceAllString(msg.Body, "${1}[REDACTED]"))
+	return nil
+}
+
+// FileMailer stores each message as an .eml file in a directory, which
+// makes delivered mail easy to inspect from tests and local runs.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file mailer requires MAIL_DIR")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errInvalidHeader
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o644)
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+}
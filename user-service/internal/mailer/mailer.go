@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email (verification, password reset).
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config selects and configures a Mailer implementation.
+type Config struct {
+	Driver string // smtp, file or log
+
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	Dir string // output directory for the file driver
+}
+
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP mailer requires SMTP_HOST")
+		}
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "file":
+		return NewFileMailer(cfg.Dir, cfg.From)
+	case "log", "":
+		return NewLogMailer(cfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Driver)
+	}
+}
+
+func format(from string, msg Message) []byte {
+	return []byte("From: " + from + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		msg.Body + "\r\n")
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	if port == "" {
+		port = "587"
+	}
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr: net.JoinHostPort(host, port), auth: auth, from: from}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errInvalidHeader
+	}
+
+	// net/smtp has no context support; run the exchange in the background
+	// so callers are not held past their deadline.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+	ExportExpired   = "expired"
+)
+
+// ExportJob tracks a personal data export (GDPR takeout) for a single user.
+type ExportJob struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"-"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	FilePath    string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
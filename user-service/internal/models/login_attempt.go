@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LoginAttempt is the failed-login counter for one email address or client IP.
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ScopeBooksRead  = "books:read"
+	ScopeBooksWrite = "books:write"
+)
+
+// PersonalAccessToken is a long-lived, user-managed credential for scripts
+// and integrations. Only the hash of the token is stored.
+//
+// LastUsedAt is set when the gateway introspects the token. The gateway
+// caches those results for up to its auth.cache.max_ttl, so it can lag
+// real use by that long.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
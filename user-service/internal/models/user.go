@@ -7,12 +7,15 @@ import (
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"` // UUID primary key
-	FullName  string    `json:"full_name"`
-	Email     string    `json:"email"`      // Unique email
-	Password  string    `json:"-"`          // Hashed password, never expose
-	Role      string    `json:"role"`       // e.g. "admin", "user"
-	CreatedAt time.Time `json:"created_at"` // Record creation timestamp
-	UpdatedAt time.Time `json:"updated_at"` // Optional update timestamp
-	DeletedAt time.Time `json:"deleted_at"` // Optional delete timestamp
+	ID              uuid.UUID  `json:"id"` // UUID primary key
+	FullName        string     `json:"full_name"`
+	Email           string     `json:"email"`             // Unique email
+	Password        string     `json:"-"`                 // Hashed password, never expose
+	Role            string     `json:"role"`              // e.g. "admin", "user"
+	EmailVerifiedAt *time.Time `json:"email_verified_at"` // Set once the email address is confirmed
+	TOTPSecret      string     `json:"-"`                 // Base32 TOTP secret, set during 2FA enrollment
+	TOTPEnabled     bool       `json:"totp_enabled"`      // 2FA is required at login once confirmed
+	CreatedAt       time.Time  `json:"created_at"`        // Record creation timestamp
+	UpdatedAt       time.Time  `json:"updated_at"`        // Optional update timestamp
+	DeletedAt       time.Time  `json:"deleted_at"`        // Optional delete timestamp
 }
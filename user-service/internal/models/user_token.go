@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeMFALogin      = "mfa_login"
+)
+
+// UserToken is a single-use, expiring token sent to the user by email. MFA
+// challenge tokens are stateless and only stored once redeemed.
+type UserToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Purpose   string
+	TokenHash string // SHA-256 of the token, the token itself is never stored
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
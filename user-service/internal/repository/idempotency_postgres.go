@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"platform/idempotency"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+type IdempotencyRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepositoryPostgres(db *sql.DB) idempotency.Store {
+	return &IdempotencyRepositoryPostgres{db: db}
+}
+
+// reserveAttempts bounds how often Reserve retries when the key it
+// collided with is released before it can be read.
+const reserveAttempts = 3
+
+func (r *IdempotencyRepositoryPostgres) Reserve(ctx context.Context, rec idempotency.Record, staleBefore time.Time) (idempotency.Record, bool, error) {
+	const clear = `
+DELETE FROM idempotency_keys
+WHERE scope = $1 AND key = $2 AND (expires_at < $3 OR (status = 0 AND created_at < $4))`
+	if _, err := r.db.ExecContext(ctx, clear, rec.Scope, rec.Key, time.Now().UTC(), staleBefore.UTC()); err != nil {
+		return rec, false, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		stored, reserved, err := r.reserve(ctx, rec)
+		// the first request was released between our insert and select
+		if errors.Is(err, sql.ErrNoRows) && attempt < reserveAttempts {
+			continue
+		}
+		return stored, reserved, err
+	}
+}
+
+func (r *IdempotencyRepositoryPostgres) reserve(ctx context.Context, rec idempotency.Record) (idempotency.Record, bool, error) {
+	const insert = `
+INSERT INTO idempotency_keys (scope, key, fingerprint, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (scope, key) DO NOTHING`
+	res, err := r.db.ExecContext(ctx, insert, rec.Scope, rec.Key, rec.Fingerprint, rec.CreatedAt.UTC(), rec.ExpiresAt.UTC())
+	if err != nil {
+		return rec, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return rec, err == nil, err
+	}
+
+	sqlStr, args, err := sq.Select("scope", "key", "fingerprint", "status", "content_type", "body", "created_at", "expires_at").
+		From("idempotency_keys").
+		Where(sq.Eq{"scope": rec.Scope, "key": rec.Key}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return rec, false, err
+	}
+	var stored idempotency.Record
+	err = r.db.QueryRowContext(ctx, sqlStr, args...).Scan(&stored.Scope, &stored.Key, &stored.Fingerprint,
+		&stored.Status, &stored.ContentType, &stored.Body, &stored.CreatedAt, &stored.ExpiresAt)
+	return stored, false, err
+}
+
+func (r *IdempotencyRepositoryPostgres) Complete(ctx context.Context, scope, key string, status int, contentType string, body []byte) error {
+	sqlStr, args, err := sq.Update("idempotency_keys").
+		Set("status", status).
+		Set("content_type", contentType).
+		Set("body", body).
+		Where(sq.Eq{"scope": scope, "key": key}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (r *IdempotencyRepositoryPostgres) Release(ctx context.Context, scope, key string) error {
+	sqlStr, args, err := sq.Delete("idempotency_keys").
+		Where(sq.Eq{"scope": scope, "key": key}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (r *IdempotencyRepositoryPostgres) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	sqlStr, args, err := sq.Delete("idempotency_keys").
+		Where(sq.Lt{"expires_at": now.UTC()}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := r.db.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+	"userService/internal/models"
+)
+
+// LoginAttemptStoreMemory keeps counters in process memory. Suitable for a
+// single instance or local development; counters are lost on restart.
+type LoginAttemptStoreMemory struct {
+	mu        sync.Mutex
+	attempts  map[string]*models.LoginAttempt
+	lastPrune time.Time
+}
+
+func NewLoginAttemptStoreMemory() LoginAttemptStore {
+	return &LoginAttemptStoreMemory{attempts: make(map[string]*models.LoginAttempt)}
+}
+
+func (s *LoginAttemptStoreMemory) Get(_ context.Context, key string) (models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.attempts[key]; ok {
+		return *a, nil
+	}
+	return models.LoginAttempt{Key: key}, nil
+}
+
+func (s *LoginAttemptStoreMemory) RecordFailure(_ context.Context, key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.pruneLocked(now, window)
+
+	a, ok := s.attempts[key]
+	if !ok {
+		a = &models.LoginAttempt{Key: key}
+		s.attempts[key] = a
+	}
+	if now.Sub(a.LastFailureAt) > window {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	return a.Failures, nil
+}
+
+func (s *LoginAttemptStoreMemory) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.attempts[key]; ok {
+		a.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *LoginAttemptStoreMemory) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// pruneLocked drops stale counters at most once per window so the map
+// can't grow without bound under a spray of distinct emails.
+func (s *LoginAttemptStoreMemory) pruneLocked(now time.Time, window time.Duration) {
+	if now.Sub(s.lastPrune) < window {
+		return
+	}
+	s.lastPrune = now
+	for key, a := range s.attempts {
+		if now.Sub(a.LastFailureAt) > window && now.After(a.LockedUntil) {
+			delete(s.attempts, key)
+		}
+	}
+}
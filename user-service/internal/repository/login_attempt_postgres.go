@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"userService/internal/models"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+type LoginAttemptStorePostgres struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptStorePostgres(db *sql.DB) LoginAttemptStore {
+	return &LoginAttemptStorePostgres{db: db}
+}
+
+func (s *LoginAttemptStorePostgres) Get(ctx context.Context, key string) (models.LoginAttempt, error) {
+	sqlStr, args, err := sq.Select("key", "failures", "last_failure_at", "locked_until").
+		From("login_attempts").
+		Where(sq.Eq{"key": key}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return models.LoginAttempt{}, err
+	}
+
+	var a models.LoginAttempt
+	var lockedUntil sql.NullTime
+	err = s.db.QueryRowContext(ctx, sqlStr, args...).Scan(&a.Key, &a.Failures, &a.LastFailureAt, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LoginAttempt{Key: key}, nil
+	}
+	if err != nil {
+		return models.LoginAttempt{}, err
+	}
+	a.LockedUntil = lockedUntil.Time
+	return a, nil
+}
+
+func (s *LoginAttemptStorePostgres) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	now := time.Now().UTC()
+
+	const query = `
+INSERT INTO login_attempts (key, failures, last_failure_at)
+VALUES ($1, 1, $2)
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+    last_failure_at = $2
+RETURNING failures`
+
+	var failures int
+	err := s.db.QueryRowContext(ctx, query, key, now, now.Add(-window)).Scan(&failures)
+	return failures, err
+}
+
+func (s *LoginAttemptStorePostgres) Lock(ctx context.Context, key string, until time.Time) error {
+	sqlStr, args, err := sq.Update("login_attempts").
+		Set("locked_until", until.UTC()).
+		Where(sq.Eq{"key": key}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (s *LoginAttemptStorePostgres) Reset(ctx context.Context, key string) error {
+	sqlStr, args, err := sq.Delete("login_attempts").
+		Where(sq.Eq{"key": key}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"userService/internal/models"
+)
+
+type LoginAttemptStore interface {
+	// Get returns the counter for key, or a zero LoginAttempt if there is none.
+	Get(ctx context.Context, key string) (models.LoginAttempt, error)
+	// RecordFailure bumps the failure count for key and returns the new value.
+	// Counting starts over when the previous failure is older than window.
+	RecordFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
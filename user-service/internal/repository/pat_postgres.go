@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"userService/internal/models"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// last_used_at is only rewritten when it is older than this, so a busy
+// script doesn't turn every request into a write.
+const lastUsedResolution = time.Minute
+
+type PersonalAccessTokenRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewPersonalAccessTokenRepositoryPostgres(db *sql.DB) PersonalAccessTokenRepository {
+	return &PersonalAccessTokenRepositoryPostgres{db: db}
+}
+
+var patColumns = []string{"id", "user_id", "name", "token_hash", "scopes", "expires_at", "last_used_at", "created_at"}
+
+func scanPAT(row sq.RowScanner) (*models.PersonalAccessToken, error) {
+	var t models.PersonalAccessToken
+	var scopes string
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	return &t, nil
+}
+
+func (r *PersonalAccessTokenRepositoryPostgres) Create(ctx context.Context, token *models.PersonalAccessToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now().UTC()
+	}
+
+	sqlStr, args, err := sq.Insert("personal_access_tokens").
+		Columns("id", "user_id", "name", "token_hash", "scopes", "expires_at", "created_at").
+		Values(token.ID, token.UserID, token.Name, token.TokenHash, strings.Join(token.Scopes, " "), token.ExpiresAt, token.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (r *PersonalAccessTokenRepositoryPostgres) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	sqlStr, args, err := sq.Select(patColumns...).
+		From("personal_access_tokens").
+		Where(sq.Eq{"user_id": userID, "revoked_at": nil}).
+		OrderBy("created_at DESC").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.PersonalAccessToken{}
+	for rows.Next() {
+		t, err := scanPAT(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *PersonalAccessTokenRepositoryPostgres) GetByHash(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	sqlStr, args, err := sq.Select(patColumns...).
+		From("personal_access_tokens").
+		Where(sq.Eq{"token_hash": tokenHash, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPAT(r.db.QueryRowContext(ctx, sqlStr, args...))
+}
+
+func (r *PersonalAccessTokenRepositoryPostgres) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	sqlStr, args, err := sq.Update("personal_access_tokens").
+		Set("revoked_at", time.Now().UTC()).
+		Where(sq.Eq{"id": id, "user_id": userID, "revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *PersonalAccessTokenRepositoryPostgres) TouchLastUsed(ctx context.Context, id uuid.UUID) error {
+	now := time.Now().UTC()
+	sqlStr, args, err := sq.Update("personal_access_tokens").
+		Set("last_used_at", now).
+		Where(sq.Eq{"id": id}).
+		Where(sq.Or{sq.Eq{"last_used_at": nil}, sq.Lt{"last_used_at": now.Add(-lastUsedResolution)}}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"context"
+	"userService/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type PersonalAccessTokenRepository interface {
+	Create(ctx context.Context, token *models.PersonalAccessToken) error
+	// ListByUser returns the user's tokens that haven't been revoked.
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.PersonalAccessToken, error)
+	// GetByHash returns sql.ErrNoRows for unknown or revoked tokens.
+	GetByHash(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error)
+	// Revoke returns sql.ErrNoRows if the user has no such active token.
+	Revoke(ctx context.Context, userID, id uuid.UUID) error
+	TouchLastUsed(ctx context.Context, id uuid.UUID) error
+}
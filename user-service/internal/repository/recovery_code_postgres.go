@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+type RecoveryCodeRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewRecoveryCodeRepositoryPostgres(db *sql.DB) RecoveryCodeRepository {
+	return &RecoveryCodeRepositoryPostgres{db: db}
+}
+
+func (r *RecoveryCodeRepositoryPostgres) Replace(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sqlStr, args, err := sq.Delete("recovery_codes").
+		Where(sq.Eq{"user_id": userID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlStr, args...); err != nil {
+		return err
+	}
+
+	if len(codeHashes) > 0 {
+		now := time.Now().UTC()
+		insert := sq.Insert("recovery_codes").
+			Columns("id", "user_id", "code_hash", "created_at").
+			PlaceholderFormat(sq.Dollar)
+		for _, h := range codeHashes {
+			insert = insert.Values(uuid.New(), userID, h, now)
+		}
+		sqlStr, args, err := insert.ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqlStr, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *RecoveryCodeRepositoryPostgres) Consume(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	sqlStr, args, err := sq.Update("recovery_codes").
+		Set("used_at", time.Now().UTC()).
+		Where(sq.Eq{"user_id": userID, "code_hash": codeHash, "used_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *RecoveryCodeRepositoryPostgres) DeleteForUser(ctx context.Context, userID uuid.UUID) error {
+	sqlStr, args, err := sq.Delete("recovery_codes").
+		Where(sq.Eq{"user_id": userID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type RecoveryCodeRepository interface {
+	// Replace discards the user's existing codes and stores the new hashes.
+	Replace(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	// Consume marks an unused code as used. It returns sql.ErrNoRows if the
+	// code doesn't exist or was already used.
+	Consume(ctx context.Context, userID uuid.UUID, codeHash string) error
+	DeleteForUser(ctx context.Context, userID uuid.UUID) error
+}
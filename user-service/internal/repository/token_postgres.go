@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"userService/internal/models"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+type TokenRepositoryPostgres struct {
+	db *sql.DB
+}
+
+func NewTokenRepositoryPostgres(db *sql.DB) TokenRepository {
+	return &TokenRepositoryPostgres{db: db}
+}
+
+func (r *TokenRepositoryPostgres) Create(ctx context.Context, token *models.UserToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now().UTC()
+	}
+
+	sqlStr, args, err := sq.Insert("user_tokens").
+		Columns("id", "user_id", "purpose", "token_hash", "expires_at", "created_at").
+		Values(token.ID, token.UserID, token.Purpose, token.TokenHash, token.ExpiresAt, token.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (r *TokenRepositoryPostgres) Redeem(ctx context.Context, token *models.UserToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	now := time.Now().UTC()
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = now
+	}
+	token.UsedAt = &now
+
+	// the unique token_hash decides which of two concurrent redemptions wins
+	sqlStr, args, err := sq.Insert("user_tokens").
+		Columns("id", "user_id", "purpose", "token_hash", "expires_at", "used_at", "created_at").
+		Values(token.ID, token.UserID, token.Purpose, token.TokenHash, token.ExpiresAt, now, token.CreatedAt).
+		Suffix("ON CONFLICT (token_hash) DO NOTHING").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *TokenRepositoryPostgres) Consume(ctx context.Context, purpose, tokenHash string) (uuid.UUID, error) {
+	now := time.Now().UTC()
+
+	// a single conditional UPDATE keeps two concurrent requests from both
+	// redeeming the same token
+	sqlStr, args, err := sq.Update("user_tokens").
+		Set("used_at", now).
+		Where(sq.Eq{"purpose": purpose, "token_hash": tokenHash, "used_at": nil}).
+		Where(sq.Gt{"expires_at": now}).
+		Suffix("RETURNING user_id").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var userID uuid.UUID
+	if err := r.db.QueryRowContext(ctx, sqlStr, args...).Scan(&userID); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+func (r *TokenRepositoryPostgres) Delete(ctx context.Context, purpose, tokenHash string) error {
+	sqlStr, args, err := sq.Delete("user_tokens").
+		Where(sq.Eq{"purpose": purpose, "token_hash": tokenHash}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (r *TokenRepositoryPostgres) DeleteForUser(ctx context.Context, userID uuid.UUID, purpose string) error {
+	sqlStr, args, err := sq.Delete("user_tokens").
+		Where(sq.Eq{"user_id": userID, "purpose": purpose}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
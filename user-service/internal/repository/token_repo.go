@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+	"userService/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type TokenRepository interface {
+	Create(ctx context.Context, token *models.UserToken) error
+	// Consume marks an unused, unexpired token as used and returns its owner.
+	// It returns sql.ErrNoRows if no such token exists.
+	Consume(ctx context.Context, purpose, tokenHash string) (uuid.UUID, error)
+	// Redeem stores a token that was not created up front as already used.
+	// It returns sql.ErrNoRows if the token was redeemed before.
+	Redeem(ctx context.Context, token *models.UserToken) error
+	// Delete removes one token, e.g. to take back a Redeem.
+	Delete(ctx context.Context, purpose, tokenHash string) error
+	// DeleteForUser removes every outstanding token of the given purpose.
+	DeleteForUser(ctx context.Context, userID uuid.UUID, purpose string) error
+}
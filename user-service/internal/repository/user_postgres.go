@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"time"
 	"userService/internal/models"
@@ -17,7 +18,19 @@ func NewUserRepositoryPostgres(db *sql.DB) UserRepository {
 	return &UserRepositoryPostgres{db: db}
 }
 
-func (u *UserRepositoryPostgres) Create(user *models.User) error {
+var userColumns = []string{"id", "full_name", "email", "password", "role", "email_verified_at", "totp_secret", "totp_enabled", "created_at", "updated_at"}
+
+func scanUser(row sq.RowScanner) (*models.User, error) {
+	var usr models.User
+	var totpSecret sql.NullString
+	if err := row.Scan(&usr.ID, &usr.FullName, &usr.Email, &usr.Password, &usr.Role, &usr.EmailVerifiedAt, &totpSecret, &usr.TOTPEnabled, &usr.CreatedAt, &usr.UpdatedAt); err != nil {
+		return nil, err
+	}
+	usr.TOTPSecret = totpSecret.String
+	return &usr, nil
+}
+
+func (u *UserRepositoryPostgres) Create(ctx context.Context, user *models.User) error {
 	// ensure ID and timestamps
 	if user.ID == uuid.Nil {
 		user.ID = uuid.New()
@@ -38,12 +51,12 @@ func (u *UserRepositoryPostgres) Create(user *models.User) error {
 		return err
 	}
 
-	_, err = u.db.Exec(sqlStr, args...)
+	_, err = u.db.ExecContext(ctx, sqlStr, args...)
 	return err
 }
 
-func (u *UserRepositoryPostgres) GetByID(id uuid.UUID) (*models.User, error) {
-	query := sq.Select("id", "full_name", "email", "password", "role", "created_at", "updated_at").
+func (u *UserRepositoryPostgres) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	query := sq.Select(userColumns...).
 		From("users").
 		Where(sq.Eq{"id": id}).
 		PlaceholderFormat(sq.Dollar).
@@ -54,16 +67,11 @@ func (u *UserRepositoryPostgres) GetByID(id uuid.UUID) (*models.User, error) {
 		return nil, err
 	}
 
-	var usr models.User
-	row := u.db.QueryRow(sqlStr, args...)
-	if err := row.Scan(&usr.ID, &usr.FullName, &usr.Email, &usr.Password, &usr.Role, &usr.CreatedAt, &usr.UpdatedAt); err != nil {
-		return nil, err
-	}
-	return &usr, nil
+	return scanUser(u.db.QueryRowContext(ctx, sqlStr, args...))
 }
 
-func (u *UserRepositoryPostgres) GetByEmail(email string) (*models.User, error) {
-	query := sq.Select("id", "full_name", "email", "password", "role", "created_at", "updated_at").
+func (u *UserRepositoryPostgres) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	query := sq.Select(userColumns...).
 		From("users").
 		Where(sq.Eq{"email": email}).
 		PlaceholderFormat(sq.Dollar).
@@ -73,10 +81,67 @@ func (u *UserRepositoryPostgres) GetByEmail(email string) (*models.User, error)
 		return nil, err
 	}
 
-	var usr models.User
-	row := u.db.QueryRow(sqlStr, args...)
-	if err := row.Scan(&usr.ID, &usr.FullName, &usr.Email, &usr.Password, &usr.Role, &usr.CreatedAt, &usr.UpdatedAt); err != nil {
-		return nil, err
+	return scanUser(u.db.QueryRowContext(ctx, sqlStr, args...))
+}
+
+func (u *UserRepositoryPostgres) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	now := time.Now().UTC()
+	return u.update(ctx, id, sq.Eq{"email_verified_at": now, "updated_at": now})
+}
+
+func (u *UserRepositoryPostgres) UpdatePassword(ctx context.Context, id uuid.UUID, hashed string) error {
+	return u.update(ctx, id, sq.Eq{"password": hashed, "updated_at": time.Now().UTC()})
+}
+
+// SetTOTP stores the TOTP secret and whether 2FA is active. An empty
+// secret clears it.
+func (u *UserRepositoryPostgres) SetTOTP(ctx context.Context, id uuid.UUID, secret string, enabled bool) error {
+	return u.update(ctx, id, sq.Eq{
+		"totp_secret":  sql.NullString{String: secret, Valid: secret != ""},
+		"totp_enabled": enabled,
+		"updated_at":   time.Now().UTC(),
+	})
+}
+
+func (u *UserRepositoryPostgres) UseTOTPStep(ctx context.Context, id uuid.UUID, step int64) error {
+	// a single conditional UPDATE, so two logins racing with the same code
+	// can't both pass
+	sqlStr, args, err := sq.Update("users").
+		Set("totp_last_step", step).
+		Where(sq.Eq{"id": id}).
+		Where(sq.Or{sq.Eq{"totp_last_step": nil}, sq.Lt{"totp_last_step": step}}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
 	}
-	return &usr, nil
+
+	res, err := u.db.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (u *UserRepositoryPostgres) update(ctx context.Context, id uuid.UUID, values map[string]interface{}) error {
+	sqlStr, args, err := sq.Update("users").
+		SetMap(values).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := u.db.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
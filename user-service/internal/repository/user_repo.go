@@ -1,13 +1,20 @@
 package repository
 
 import (
+	"context"
 	"userService/internal/models"
 
 	"github.com/google/uuid"
 )
 
 type UserRepository interface {
-	GetByID(id uuid.UUID) (*models.User, error)
-	GetByEmail(email string) (*models.User, error)
-	Create(user *models.User) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	Create(ctx context.Context, user *models.User) error
+	MarkEmailVerified(ctx context.Context, id uuid.UUID) error
+	UpdatePassword(ctx context.Context, id uuid.UUID, hashed string) error
+	SetTOTP(ctx context.Context, id uuid.UUID, secret string, enabled bool) error
+	// UseTOTPStep records step as the last accepted TOTP time step. It
+	// returns sql.ErrNoRows unless step is newer than the one stored.
+	UseTOTPStep(ctx context.Context, id uuid.UUID, step int64) error
 }
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"userService/internal/mailer"
+	"userService/internal/models"
+	"userService/internal/repository"
+	"userService/util"
+
+	"github.com/google/uuid"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+	minPasswordLen   = 8
+
+	// requestTimeout bounds the background work behind ForgotPassword and
+	// ResendVerification.
+	requestTimeout = 30 * time.Second
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrWeakPassword = fmt.Errorf("password must be at least %d characters", minPasswordLen)
+)
+
+// AccountService implements the email-driven account flows: address
+// verification and password reset.
+type AccountService struct {
+	users   repository.UserRepository
+	tokens  repository.TokenRepository
+	mailer  mailer.Mailer
+	baseURL string // front-end URL the links in emails point to
+}
+
+func NewAccountService(users repository.UserRepository, tokens repository.TokenRepository, m mailer.Mailer, baseURL string) *AccountService {
+	return &AccountService{users: users, tokens: tokens, mailer: m, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// SendVerification emails a fresh verification link to the user.
+func (s *AccountService) SendVerification(ctx context.Context, user *models.User) error {
+	token, err := s.issue(ctx, user.ID, models.TokenPurposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your BookLog email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s/verify-email?token=%s\n\nThe link expires in %s.",
+			user.FullName, s.baseURL, url.QueryEscape(token), verifyEmailTTL),
+	})
+}
+
+// ResendVerification sends a new verification link to the account with
+// this email unless it is verified already; earlier links stop working.
+// Like ForgotPassword it runs in the background.
+func (s *AccountService) ResendVerification(ctx context.Context, email string) {
+	s.background(ctx, "verification resend", func(ctx context.Context) error {
+		return s.resendVerification(ctx, email)
+	})
+}
+
+func (s *AccountService) resendVerification(ctx context.Context, email string) error {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	if err := s.tokens.DeleteForUser(ctx, user.ID, models.TokenPurposeVerifyEmail); err != nil {
+		return err
+	}
+	return s.SendVerification(ctx, user)
+}
+
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	userID, err := s.consume(ctx, models.TokenPurposeVerifyEmail, token)
+	if err != nil {
+		return err
+	}
+	return s.users.MarkEmailVerified(ctx, userID)
+}
+
+// ForgotPassword sends a reset link if the email belongs to an account.
+// The lookup and the mail happen in the background, so neither the
+// answer nor how long it takes tells which addresses are registered.
+func (s *AccountService) ForgotPassword(ctx context.Context, email string) {
+	s.background(ctx, "password reset request", func(ctx context.Context) error {
+		return s.forgotPassword(ctx, email)
+	})
+}
+
+func (s *AccountService) forgotPassword(ctx context.Context, email string) error {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issue(ctx, user.ID, models.TokenPurposeResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your BookLog password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. If that was you, open the link below:\n\n%s/reset-password?token=%s\n\nThe link expires in %s. If you didn't ask for this you can ignore this email.",
+			user.FullName, s.baseURL, url.QueryEscape(token), resetPasswordTTL),
+	})
+}
+
+func (s *AccountService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if len(newPassword) < minPasswordLen {
+		return ErrWeakPassword
+	}
+
+	userID, err := s.consume(ctx, models.TokenPurposeResetPassword, token)
+	if err != nil {
+		return err
+	}
+
+	hashed, err := util.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.users.UpdatePassword(ctx, userID, hashed); err != nil {
+		return err
+	}
+
+	// any other reset links that are still in flight are now stale
+	if err := s.tokens.DeleteForUser(ctx, userID, models.TokenPurposeResetPassword); err != nil {
+		log.Printf("failed to clear reset tokens for %s: %v", userID, err)
+	}
+	return nil
+}
+
+// background runs fn detached from the request and logs its error.
+func (s *AccountService) background(ctx context.Context, what string, fn func(context.Context) error) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), requestTimeout)
+		defer cancel()
+		if err := fn(ctx); err != nil {
+			log.Printf("%s failed: %v", what, err)
+		}
+	}()
+}
+
+func (s *AccountService) issue(ctx context.Context, userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := util.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.tokens.Create(ctx, &models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *AccountService) consume(ctx context.Context, purpose, token string) (uuid.UUID, error) {
+	if token == "" {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	userID, err := s.tokens.Consume(ctx, purpose, util.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return userID, err
+}
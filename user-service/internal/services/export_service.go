@@ -0,0 +1,260 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"userService/internal/clients"
+	"userService/internal/models"
+	"userService/internal/repository"
+	"userService/util"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrExportNotFound    = errors.New("export not found")
+	ErrExportNotReady    = errors.New("export not ready")
+	ErrExportLinkInvalid = errors.New("invalid or expired download link")
+)
+
+// ExportService builds personal data archives. Jobs are kept in memory and
+// the archives on local disk, so both are lost on restart; users simply
+// request a new export in that case.
+type ExportService struct {
+	repo    repository.UserRepository
+	books   *clients.BookClient
+	dir     string
+	linkTTL time.Duration
+	signKey []byte // derived from the JWT secret, for download links only
+
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*models.ExportJob
+}
+
+func NewExportService(repo repository.UserRepository, books *clients.BookClient, dir string, linkTTL time.Duration, secret string) *ExportService {
+	return &ExportService{
+		repo:    repo,
+		books:   books,
+		dir:     dir,
+		linkTTL: linkTTL,
+		signKey: util.DeriveKey(secret, "booklog-export-link"),
+		jobs:    make(map[uuid.UUID]*models.ExportJob),
+	}
+}
+
+// Start queues an export for userID and returns immediately.
+func (s *ExportService) Start(ctx context.Context, userID uuid.UUID) (models.ExportJob, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return models.ExportJob{}, err
+	}
+
+	job := &models.ExportJob{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Status:    models.ExportPending,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+
+	// the job outlives the request but stays part of its trace
+	go s.run(context.WithoutCancel(ctx), job.ID)
+
+	return *job, nil
+}
+
+// Get returns a job owned by userID.
+func (s *ExportService) Get(userID, jobID uuid.UUID) (models.ExportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[jobID]
+	if !ok || job.UserID != userID {
+		return models.ExportJob{}, ErrExportNotFound
+	}
+	s.expireLocked(job, time.Now())
+	return *job, nil
+}
+
+// DownloadURL returns a relative, signed link to a completed archive. The
+// link stops working when the archive expires.
+func (s *ExportService) DownloadURL(job models.ExportJob) (string, error) {
+	if job.Status != models.ExportCompleted || job.ExpiresAt == nil {
+		return "", ErrExportNotReady
+	}
+	expires := strconv.FormatInt(job.ExpiresAt.Unix(), 10)
+	return fmt.Sprintf("/exports/%s/download?expires=%s&signature=%s", job.ID, expires, s.sign(job.ID, expires)), nil
+}
+
+// OpenDownload validates a signed link and returns the path of the archive.
+func (s *ExportService) OpenDownload(jobID uuid.UUID, expires, signature string) (string, error) {
+	if !hmac.Equal([]byte(signature), []byte(s.sign(jobID, expires))) {
+		return "", ErrExportLinkInvalid
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", ErrExportLinkInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[jobID]
+	if !ok || job.Status != models.ExportCompleted {
+		return "", ErrExportLinkInvalid
+	}
+	return job.FilePath, nil
+}
+
+// RunCleanup periodically deletes archives whose download window has passed.
+func (s *ExportService) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for id, job := range s.jobs {
+				s.expireLocked(job, now)
+				// forget finished jobs a day after they were created
+				if job.Status != models.ExportRunning && job.Status != models.ExportPending && now.Sub(job.CreatedAt) > 24*time.Hour {
+					delete(s.jobs, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *ExportService) expireLocked(job *models.ExportJob, now time.Time) {
+	if job.Status != models.ExportCompleted || job.ExpiresAt == nil || now.Before(*job.ExpiresAt) {
+		return
+	}
+	if err := os.Remove(job.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("export %s: failed to remove archive: %v", job.ID, err)
+	}
+	job.Status = models.ExportExpired
+	job.FilePath = ""
+}
+
+func (s *ExportService) sign(jobID uuid.UUID, expires string) string {
+	mac := hmac.New(sha256.New, s.signKey)
+	mac.Write([]byte(jobID.String() + ":" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *ExportService) run(ctx context.Context, jobID uuid.UUID) {
+	s.mu.Lock()
+	job := s.jobs[jobID]
+	job.Status = models.ExportRunning
+	userID := job.UserID
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, jobID.String()+".zip")
+	err := s.build(ctx, userID, path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	job.CompletedAt = &now
+	if err != nil {
+		log.Printf("export %s failed: %v", jobID, err)
+		os.Remove(path)
+		job.Status = models.ExportFailed
+		job.Error = "export failed"
+		return
+	}
+	expires := now.Add(s.linkTTL)
+	job.Status = models.ExportCompleted
+	job.FilePath = path
+	job.ExpiresAt = &expires
+}
+
+func (s *ExportService) build(ctx context.Context, userID uuid.UUID, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("load user: %w", err)
+	}
+	books, err := s.books.GetUserBooks(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("load books: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	if err := writeJSON(zw, "user.json", user); err != nil {
+		return err
+	}
+	if err := writeCSV(zw, "user.csv", [][]string{
+		{"id", "full_name", "email", "role", "created_at", "updated_at"},
+		{user.ID.String(), user.FullName, user.Email, user.Role, user.CreatedAt.Format(time.RFC3339), user.UpdatedAt.Format(time.RFC3339)},
+	}); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "books.json", books); err != nil {
+		return err
+	}
+	rows := [][]string{{"id", "title", "author", "description", "year", "created_at", "updated_at"}}
+	for _, b := range books {
+		rows = append(rows, []string{
+			strconv.FormatUint(uint64(b.ID), 10), b.Title, b.Author, b.Description, strconv.Itoa(b.Year),
+			b.CreatedAt.Format(time.RFC3339), b.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	if err := writeCSV(zw, "books.csv", rows); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeCSV(zw *zip.Writer, name string, rows [][]string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
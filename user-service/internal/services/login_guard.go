@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"userService/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	loginFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_login_failures_total",
+		Help: "Failed login attempts.",
+	})
+	loginLockouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_login_lockouts_total",
+		Help: "Temporary lockouts applied after repeated failed logins, by key type.",
+	}, []string{"scope"})
+	loginBlocked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_login_blocked_total",
+		Help: "Login attempts rejected because the email or client IP was locked.",
+	})
+)
+
+// LockedError is returned while an email or client IP is locked out.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+type LoginGuardConfig struct {
+	FreeAttempts int           // failures allowed before backoff starts
+	BaseDelay    time.Duration // first lockout, doubled on every further failure
+	MaxLockout   time.Duration
+	Window       time.Duration // failures older than this are forgotten
+}
+
+// LoginGuard applies exponential backoff to repeated failed logins, tracked
+// both per email and per client IP. The IP limit is looser because many
+// users can share an address.
+type LoginGuard struct {
+	store repository.LoginAttemptStore
+	cfg   LoginGuardConfig
+}
+
+const ipAttemptMultiplier = 4
+
+func NewLoginGuard(store repository.LoginAttemptStore, cfg LoginGuardConfig) *LoginGuard {
+	return &LoginGuard{store: store, cfg: cfg}
+}
+
+// Check returns a *LockedError if either key is currently locked.
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	now := time.Now()
+	var wait time.Duration
+
+	for _, key := range g.keys(email, ip) {
+		a, err := g.store.Get(ctx, key)
+		if err != nil {
+			// fail open: a broken counter store must not lock everyone out
+			log.Printf("login guard: reading %s: %v", key, err)
+			continue
+		}
+		if d := a.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		loginBlocked.Inc()
+		return &LockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Fail records a failed attempt and locks the keys that crossed their limit.
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string) {
+	loginFailures.Inc()
+
+	for i, key := range g.keys(email, ip) {
+		free := g.cfg.FreeAttempts
+		scope := "email"
+		if i == 1 {
+			free *= ipAttemptMultiplier
+			scope = "ip"
+		}
+
+		failures, err := g.store.RecordFailure(ctx, key, g.cfg.Window)
+		if err != nil {
+			log.Printf("login guard: recording %s: %v", key, err)
+			continue
+		}
+		if failures <= free {
+			continue
+		}
+
+		if err := g.store.Lock(ctx, key, time.Now().Add(g.lockout(failures-free))); err != nil {
+			log.Printf("login guard: locking %s: %v", key, err)
+			continue
+		}
+		loginLockouts.WithLabelValues(scope).Inc()
+	}
+}
+
+// Succeed clears the email counter. The IP counter is left alone so that
+// one valid account doesn't reset a credential-stuffing run from that IP.
+func (g *LoginGuard) Succeed(ctx context.Context, email string) {
+	if err := g.store.Reset(ctx, emailKey(email)); err != nil {
+		log.Printf("login guard: resetting %s: %v", emailKey(email), err)
+	}
+}
+
+func (g *LoginGuard) lockout(excess int) time.Duration {
+	d := time.Duration(float64(g.cfg.BaseDelay) * math.Pow(2, float64(excess-1)))
+	if d <= 0 || d > g.cfg.MaxLockout {
+		return g.cfg.MaxLockout
+	}
+	return d
+}
+
+func (g *LoginGuard) keys(email, ip string) []string {
+	return []string{emailKey(email), "ip:" + ip}
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"userService/internal/models"
+	"userService/internal/repository"
+	"userService/util"
+
+	"github.com/google/uuid"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication enrollment not started")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid authentication code")
+	ErrInvalidPassword   = errors.New("invalid password")
+)
+
+// TOTPEnrollment is returned when a user starts setting up 2FA. URI is the
+// otpauth:// payload to render as a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAService manages TOTP enrollment and the second step of login.
+type MFAService struct {
+	users    repository.UserRepository
+	recovery repository.RecoveryCodeRepository
+	tokens   repository.TokenRepository
+	guard    *LoginGuard
+	secret   string
+	issuer   string
+}
+
+func NewMFAService(users repository.UserRepository, recovery repository.RecoveryCodeRepository, tokens repository.TokenRepository, guard *LoginGuard, secret, issuer string) *MFAService {
+	return &MFAService{users: users, recovery: recovery, tokens: tokens, guard: guard, secret: secret, issuer: issuer}
+}
+
+// Enroll generates a new, not yet active TOTP secret for the user.
+func (s *MFAService) Enroll(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.SetTOTP(ctx, userID, secret, false); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: util.TOTPURI(s.issuer, user.Email, secret)}, nil
+}
+
+// Confirm activates 2FA once the user proves their authenticator works and
+// returns a fresh set of recovery codes. The codes are only shown here.
+func (s *MFAService) Confirm(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := util.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if ok {
+		// recorded here too, so the confirmation code can't log in again
+		if ok, err = s.useTOTPStep(ctx, userID, step); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recovery.Replace(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	if err := s.users.SetTOTP(ctx, userID, user.TOTPSecret, true); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns 2FA off after re-checking the account password.
+func (s *MFAService) Disable(ctx context.Context, userID uuid.UUID, password string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !util.CheckPasswordHash(password, user.Password) {
+		return ErrInvalidPassword
+	}
+	if !user.TOTPEnabled {
+		return ErrMFANotEnabled
+	}
+
+	if err := s.users.SetTOTP(ctx, userID, "", false); err != nil {
+		return err
+	}
+	return s.recovery.DeleteForUser(ctx, userID)
+}
+
+// CompleteLogin exchanges an MFA challenge token plus a TOTP or recovery
+// code for an access token. Wrong codes count towards the login lockout;
+// the challenge token can be retried until it is redeemed once.
+func (s *MFAService) CompleteLogin(ctx context.Context, mfaToken, code, clientIP string) (*LoginResult, error) {
+	challenge, err := util.ParseMFAToken(mfaToken, s.secret)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	user, err := s.users.GetByID(ctx, challenge.UserID)
+	if err != nil || !user.TOTPEnabled {
+		return nil, errInvalidCredentials
+	}
+
+	if err := s.guard.Check(ctx, user.Email, clientIP); err != nil {
+		return nil, err
+	}
+
+	// redeem the challenge before the code is spent, so a replayed or
+	// concurrent submission can't burn a recovery code or TOTP step
+	challengeHash := util.HashToken(challenge.ID)
+	err = s.tokens.Redeem(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeMFALogin,
+		TokenHash: challengeHash,
+		ExpiresAt: challenge.ExpiresAt,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.verifyCode(ctx, user, code)
+	if err != nil || !ok {
+		// give the challenge back for another try
+		if err := s.tokens.Delete(context.WithoutCancel(ctx), models.TokenPurposeMFALogin, challengeHash); err != nil {
+			log.Printf("failed to release MFA challenge for %s: %v", user.ID, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.guard.Fail(ctx, user.Email, clientIP)
+		return nil, ErrInvalidMFACode
+	}
+	s.guard.Succeed(ctx, user.Email)
+
+	token, exp, err := util.GenerateJWT(user.ID, user.Role, s.secret)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{AccessToken: token, ExpiresAt: exp}, nil
+}
+
+func (s *MFAService) verifyCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := util.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		return s.useTOTPStep(ctx, user.ID, step)
+	}
+
+	// anything that isn't a 6-digit TOTP is tried as a recovery code
+	err := s.recovery.Consume(ctx, user.ID, util.HashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// useTOTPStep accepts each TOTP code at most once: its time step has to be
+// newer than the last one accepted for the user.
+func (s *MFAService) useTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	err := s.users.UseTOTPStep(ctx, userID, step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := hex.EncodeToString(b)
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, util.HashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"userService/internal/models"
+	"userService/internal/repository"
+	"userService/util"
+
+	"github.com/google/uuid"
+)
+
+// PATPrefix marks personal access tokens so the gateway can tell them
+// apart from JWTs without a lookup.
+const PATPrefix = "blp_"
+
+// exchangedTokenTTL bounds the JWT minted for a PAT on each introspection.
+const exchangedTokenTTL = 5 * time.Minute
+
+var (
+	ErrInvalidScopes = errors.New("scopes must be one or more of books:read, books:write")
+	ErrTokenName     = errors.New("token name is required")
+	ErrTokenExpiry   = errors.New("expiry must be in the future")
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+var validScopes = map[string]bool{
+	models.ScopeBooksRead:  true,
+	models.ScopeBooksWrite: true,
+}
+
+// TokenIntrospection follows RFC 7662. AccessToken is a short-lived JWT
+// carrying the PAT's scopes, which the gateway forwards to the backends.
+type TokenIntrospection struct {
+	Active      bool     `json:"active"`
+	Sub         string   `json:"sub,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	Exp         int64    `json:"exp,omitempty"`
+	AccessToken string   `json:"access_token,omitempty"`
+	Scopes      []string `json:"-"`
+}
+
+type PATService struct {
+	repo   repository.PersonalAccessTokenRepository
+	users  repository.UserRepository
+	secret string
+}
+
+func NewPATService(repo repository.PersonalAccessTokenRepository, users repository.UserRepository, secret string) *PATService {
+	return &PATService{repo: repo, users: users, secret: secret}
+}
+
+// Create issues a new token. The plaintext is returned once and never
+// stored.
+func (s *PATService) Create(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (string, *models.PersonalAccessToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, ErrTokenName
+	}
+	if len(scopes) == 0 {
+		return "", nil, ErrInvalidScopes
+	}
+	for _, sc := range scopes {
+		if !validScopes[sc] {
+			return "", nil, ErrInvalidScopes
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, ErrTokenExpiry
+	}
+
+	secret, _, err := util.GenerateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	plain := PATPrefix + secret
+
+	token := &models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: util.HashToken(plain),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.repo.Create(ctx, token); err != nil {
+		return "", nil, err
+	}
+	return plain, token, nil
+}
+
+func (s *PATService) List(ctx context.Context, userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	return s.repo.ListByUser(ctx, userID)
+}
+
+func (s *PATService) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	err := s.repo.Revoke(ctx, userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTokenNotFound
+	}
+	return err
+}
+
+// Introspect resolves a PAT for the gateway. Unknown, revoked and expired
+// tokens are reported as inactive rather than as errors.
+func (s *PATService) Introspect(ctx context.Context, plain string) (*TokenIntrospection, error) {
+	if !strings.HasPrefix(plain, PATPrefix) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	token, err := s.repo.GetByHash(ctx, util.HashToken(plain))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	// the gateway signs the owner's role into the identity it forwards
+	user, err := s.users.GetByID(ctx, token.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exp := now.Add(exchangedTokenTTL)
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(exp) {
+		exp = *token.ExpiresAt
+	}
+	access, err := util.GenerateScopedJWT(token.UserID, token.Scopes, exp, s.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.TouchLastUsed(ctx, token.ID); err != nil {
+		log.Printf("failed to update last use of token %s: %v", token.ID, err)
+	}
+
+	return &TokenIntrospection{
+		Active:      true,
+		Sub:         token.UserID.String(),
+		Role:        user.Role,
+		Scope:       strings.Join(token.Scopes, " "),
+		Scopes:      token.Scopes,
+		Exp:         exp.Unix(),
+		AccessToken: access,
+	}, nil
+}
@@ -1,7 +1,10 @@
 package services
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"log"
 	"time"
 	"userService/internal/models"
 	"userService/internal/repository"
@@ -10,24 +13,35 @@ import (
 	"github.com/google/uuid"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
 type UserService struct {
 	repo   repository.UserRepository
 	secret string
+	guard  *LoginGuard
+
+	// bcrypt hash checked for unknown emails so that they take as long to
+	// reject as a wrong password
+	dummyHash string
 }
 
-func NewUserService(repo repository.UserRepository, secret string) *UserService {
-	return &UserService{repo: repo, secret: secret}
+func NewUserService(repo repository.UserRepository, secret string, guard *LoginGuard) *UserService {
+	dummy, err := util.HashPassword("booklog-timing-equalizer")
+	if err != nil {
+		log.Fatalf("failed to prepare password hash: %v", err)
+	}
+	return &UserService{repo: repo, secret: secret, guard: guard, dummyHash: dummy}
 }
 
-func (s *UserService) Register(fullname, email, password string) error {
+func (s *UserService) Register(ctx context.Context, fullname, email, password string) (*models.User, error) {
 	// check existing user by email
-	if _, err := s.repo.GetByEmail(email); err == nil {
-		return errors.New("user already exists")
+	if _, err := s.repo.GetByEmail(ctx, email); err == nil {
+		return nil, errors.New("user already exists")
 	}
 
 	hashed, err := util.HashPassword(password)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	u := &models.User{
@@ -38,27 +52,66 @@ func (s *UserService) Register(fullname, email, password string) error {
 		Role:     "user",
 	}
 
-	if err := s.repo.Create(u); err != nil {
-		return err
+	if err := s.repo.Create(ctx, u); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return u, nil
 }
 
-func (s *UserService) Login(email, password string) (string, time.Time, error) {
-	user, err := s.repo.GetByEmail(email)
+// LoginResult carries either an access token or, for accounts with 2FA
+// enabled, the MFA challenge token to exchange for one.
+type LoginResult struct {
+	AccessToken string
+	ExpiresAt   time.Time
+	MFARequired bool
+	MFAToken    string
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// Login returns a *LockedError while the email or client IP is locked out
+// after too many failures.
+func (s *UserService) Login(ctx context.Context, email, password, clientIP string) (*LoginResult, error) {
+	if err := s.guard.Check(ctx, email, clientIP); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetByEmail(ctx, email)
 	if err != nil {
-		return "", time.Time{}, errors.New("invalid credentials")
+		util.CheckPasswordHash(password, s.dummyHash)
+		s.guard.Fail(ctx, email, clientIP)
+		return nil, errInvalidCredentials
 	}
 
 	if !util.CheckPasswordHash(password, user.Password) {
-		return "", time.Time{}, errors.New("invalid credentials")
+		s.guard.Fail(ctx, email, clientIP)
+		return nil, errInvalidCredentials
+	}
+
+	// the failure counter is only cleared once the second factor passes
+	if user.TOTPEnabled {
+		mfaToken, exp, err := util.GenerateMFAToken(user.ID, s.secret)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: mfaToken, ExpiresAt: exp}, nil
 	}
+	s.guard.Succeed(ctx, email)
 
-	token, exp, err := util.GenerateJWT(user.ID, s.secret)
+	token, exp, err := util.GenerateJWT(user.ID, user.Role, s.secret)
 	if err != nil {
-		return "", time.Time{}, err
+		return nil, err
 	}
 
-	return token, exp, err
+	return &LoginResult{AccessToken: token, ExpiresAt: exp}, nil
+}
+
+// GetProfile returns the user's own account.
+func (s *UserService) GetProfile(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	user, err := s.repo.GetByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
 }
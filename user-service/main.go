@@ -1,42 +1,155 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"platform/health"
+	"platform/idempotency"
+	"platform/logging"
+	"platform/metrics"
+	"platform/request"
+	"platform/tracing"
+	"syscall"
+	"time"
 	"userService/config"
 	"userService/database"
 	"userService/handlers"
+	"userService/internal/clients"
+	"userService/internal/mailer"
 	"userService/internal/repository"
 	"userService/internal/services"
 	"userService/middleware"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const serviceName = "user-service"
+
 func main() {
+	if err := logging.Setup(serviceName); err != nil {
+		log.Fatal("❌ Logging setup error:", err)
+	}
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatal("❌ Failed to load config:", err)
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName)
+	if err != nil {
+		log.Fatal("❌ Tracing setup error:", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Connect to DB using config
 	db, err := database.Connect(cfg)
 	if err != nil {
 		log.Fatal("❌ Database connection error:", err)
 	}
 	defer db.Close()
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "userdb"))
+
+	mail, err := mailer.New(mailer.Config{
+		Driver:       cfg.Mailer,
+		From:         cfg.MailFrom,
+		SMTPHost:     cfg.SMTPHost,
+		SMTPPort:     cfg.SMTPPort,
+		SMTPUsername: cfg.SMTPUsername,
+		SMTPPassword: cfg.SMTPPassword,
+		Dir:          cfg.MailDir,
+	})
+	if err != nil {
+		log.Fatal("❌ Mailer setup error:", err)
+	}
 
 	userRepo := repository.NewUserRepositoryPostgres(db)
-	userService := services.NewUserService(userRepo, cfg.JwtSecret)
-	userHandler := handlers.NewUserHandler(userService)
+	tokenRepo := repository.NewTokenRepositoryPostgres(db)
+
+	var attemptStore repository.LoginAttemptStore
+	switch cfg.LoginAttemptStore {
+	case "memory":
+		attemptStore = repository.NewLoginAttemptStoreMemory()
+	case "postgres":
+		attemptStore = repository.NewLoginAttemptStorePostgres(db)
+	default:
+		log.Fatalf("❌ Unknown LOGIN_ATTEMPT_STORE %q", cfg.LoginAttemptStore)
+	}
+	loginGuard := services.NewLoginGuard(attemptStore, services.LoginGuardConfig{
+		FreeAttempts: cfg.LoginFreeAttempts,
+		BaseDelay:    cfg.LoginLockoutBase,
+		MaxLockout:   cfg.LoginLockoutMax,
+		Window:       cfg.LoginFailureWindow,
+	})
+
+	userService := services.NewUserService(userRepo, cfg.JwtSecret, loginGuard)
+	accountService := services.NewAccountService(userRepo, tokenRepo, mail, cfg.AppBaseURL)
+	userHandler := handlers.NewUserHandler(userService, accountService)
+
+	recoveryRepo := repository.NewRecoveryCodeRepositoryPostgres(db)
+	mfaService := services.NewMFAService(userRepo, recoveryRepo, tokenRepo, loginGuard, cfg.JwtSecret, cfg.TOTPIssuer)
+	mfaHandler := handlers.NewMFAHandler(mfaService)
+	accountHandler := handlers.NewAccountHandler(accountService)
+
+	patRepo := repository.NewPersonalAccessTokenRepositoryPostgres(db)
+	patService := services.NewPATService(patRepo, userRepo, cfg.JwtSecret)
+	patHandler := handlers.NewPATHandler(patService)
+
+	bookClient := clients.NewBookClient(cfg.BookServiceURL, cfg.ServiceToken)
+	exportService := services.NewExportService(userRepo, bookClient, cfg.ExportDir, cfg.ExportLinkTTL, cfg.JwtSecret)
+	exportHandler := handlers.NewExportHandler(exportService)
+	probes := health.NewHandler(db)
+	idempotencyKeys := idempotency.NewHandler(repository.NewIdempotencyRepositoryPostgres(db), cfg.IdempotencyTTL, cfg.JwtSecret)
 
-	r := gin.Default()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go exportService.RunCleanup(ctx, time.Minute)
+	go idempotencyKeys.RunCleanup(ctx, 10*time.Minute)
 
-	r.POST("/register", userHandler.Register)
+	r := gin.New()
+	r.SetTrustedProxies(nil)
+	r.Use(middleware.ClientIPMiddleware(cfg.AuthMode == "gateway", cfg.IdentitySecret), request.IDMiddleware(), logging.Middleware(), tracing.Middleware(serviceName), metrics.Middleware())
+	// registered last, so a recovered panic reaches the access log, trace
+	// and metrics as an ordinary 500
+	r.Use(logging.Recovery())
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/livez", probes.Livez)
+	r.GET("/readyz", probes.Readyz)
+
+	r.POST("/register", idempotencyKeys.Middleware(), userHandler.Register)
 	r.POST("/login", userHandler.Login)
+	r.POST("/login/mfa", mfaHandler.LoginMFA)
+	r.POST("/verify-email", accountHandler.VerifyEmail)
+	r.POST("/verify-email/resend", accountHandler.ResendVerification)
+	r.POST("/password/forgot", accountHandler.ForgotPassword)
+	r.POST("/password/reset", accountHandler.ResetPassword)
+
+	// signed, time-limited links; the signature is the authorization
+	r.GET("/exports/:id/download", exportHandler.Download)
+
+	// the gateway introspects personal access tokens here; no gateway route
+	// forwards client requests to /internal
+	internal := r.Group("/internal")
+	internal.Use(middleware.ServiceAuthMiddleware(cfg.ServiceToken))
+	internal.POST("/tokens/introspect", patHandler.Introspect)
+
+	authMiddleware := middleware.AuthMiddleware(cfg.JwtSecret)
+	if cfg.AuthMode == "gateway" {
+		if cfg.IdentitySecret == "" {
+			log.Println("⚠️ AUTH_MODE=gateway without GATEWAY_IDENTITY_SECRET: identity headers are trusted unsigned")
+		}
+		authMiddleware = middleware.GatewayIdentityMiddleware(cfg.IdentitySecret)
+	}
 
 	auth := r.Group("/")
-	auth.Use(middleware.AuthMiddleware(cfg.JwtSecret))
+	auth.Use(authMiddleware)
 
 	auth.GET("/secret", func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
@@ -51,6 +164,38 @@ func main() {
 		})
 	})
 
-	// Server will listen on 0.0.0.0:8080 (localhost:8080 on Windows)
-	r.Run()
+	auth.GET("/users/me", userHandler.Me)
+
+	auth.POST("/users/me/2fa/enroll", mfaHandler.Enroll)
+	auth.POST("/users/me/2fa/confirm", mfaHandler.Confirm)
+	auth.POST("/users/me/2fa/disable", mfaHandler.Disable)
+
+	auth.POST("/users/me/tokens", patHandler.Create)
+	auth.GET("/users/me/tokens", patHandler.List)
+	auth.DELETE("/users/me/tokens/:id", patHandler.Revoke)
+
+	auth.POST("/users/me/export", exportHandler.StartExport)
+	auth.GET("/users/me/export/:id", exportHandler.GetExport)
+
+	srv := &http.Server{Addr: ":" + cfg.AppPort, Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("🚀 User service running on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("❌ Server error:", err)
+		}
+	}()
+
+	// On SIGTERM fail readiness and let requests in flight finish
+	<-ctx.Done()
+	log.Printf("shutting down: readiness off for %s, then draining for up to %s", cfg.PreStopDelay, cfg.ShutdownTimeout)
+	probes.Drain()
+	// new requests keep arriving until the load balancer sees /readyz fail
+	time.Sleep(cfg.PreStopDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	log.Println("User service stopped")
 }
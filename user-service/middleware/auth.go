@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"userService/util"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -24,7 +25,7 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 		}
 		tokenString := parts[1]
 
-		claims := &jwt.RegisteredClaims{}
+		claims := &util.Claims{}
 		token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
 			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, jwt.ErrTokenUnverifiable
@@ -36,6 +37,13 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 			return
 		}
 
+		// scoped tokens come from personal access tokens, which only grant
+		// access to books
+		if claims.Scope != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token scope does not allow this resource"})
+			return
+		}
+
 		if claims.Subject == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
 			return
@@ -48,6 +56,7 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 		}
 
 		c.Set("user_id", uid)
+		c.Set("role", claims.Role)
 		c.Next()
 	}
 }
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIPMiddleware stores the caller's address as "client_ip", which the
+// login guard keys lockouts on. Behind the gateway that is X-Client-IP,
+// taken only from a request whose identity signature verifies (or from any
+// request when no secret is configured, as with GatewayIdentityMiddleware).
+// Otherwise it is the TCP peer; forwarding headers are never trusted.
+func ClientIPMiddleware(behindGateway bool, secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := c.RemoteIP()
+		if ip := c.GetHeader("X-Client-IP"); behindGateway && ip != "" {
+			if secret == "" || validIdentitySignature(secret, c.GetHeader("X-User-ID"), c.GetHeader("X-User-Role"),
+				c.GetHeader("X-User-Scopes"), ip, c.GetHeader("X-Identity-Timestamp"), c.GetHeader("X-Identity-Signature")) {
+				clientIP = ip
+			}
+		}
+		c.Set("client_ip", clientIP)
+		c.Next()
+	}
+}
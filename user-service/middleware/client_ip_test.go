@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientIPMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		behindGateway bool
+		secret        string
+		req           func() *http.Request
+		want          string
+	}{
+		{"signed by the gateway", true, testIdentitySecret, func() *http.Request {
+			return identityRequest("", "", "", "192.0.2.1", now)
+		}, "192.0.2.1"},
+		{"tampered client ip", true, testIdentitySecret, func() *http.Request {
+			r := identityRequest("", "", "", "192.0.2.1", now)
+			r.Header.Set("X-Client-IP", "203.0.113.9")
+			return r
+		}, "10.0.0.2"},
+		{"expired signature", true, testIdentitySecret, func() *http.Request {
+			return identityRequest("", "", "", "192.0.2.1", now.Add(-2*identityMaxSkew))
+		}, "10.0.0.2"},
+		{"not behind the gateway", false, testIdentitySecret, func() *http.Request {
+			return identityRequest("", "", "", "192.0.2.1", now)
+		}, "10.0.0.2"},
+		{"forwarding headers are ignored", false, "", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.0.0.2:4000"
+			r.Header.Set("X-Forwarded-For", "203.0.113.9")
+			r.Header.Set("X-Real-IP", "203.0.113.9")
+			return r
+		}, "10.0.0.2"},
+		{"unsigned gateway", true, "", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.0.0.2:4000"
+			r.Header.Set("X-Client-IP", "192.0.2.1")
+			return r
+		}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.SetTrustedProxies(nil)
+			r.Use(ClientIPMiddleware(tt.behindGateway, tt.secret))
+			r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.GetString("client_ip")) })
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req())
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("client_ip %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
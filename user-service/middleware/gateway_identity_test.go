@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testIdentitySecret = "s3cret"
+
+// identityRequest builds the request the gateway would send, signed at ts.
+func identityRequest(userID, role, scopes, clientIP string, ts time.Time) *http.Request {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testIdentitySecret))
+	mac.Write([]byte(strings.Join([]string{userID, role, scopes, clientIP, unix}, "\n")))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:4000" // the gateway
+	for name, value := range map[string]string{
+		"X-User-ID":            userID,
+		"X-User-Role":          role,
+		"X-User-Scopes":        scopes,
+		"X-Client-IP":          clientIP,
+		"X-Identity-Timestamp": unix,
+		"X-Identity-Signature": hex.EncodeToString(mac.Sum(nil)),
+	} {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	return req
+}
+
+func TestGatewayIdentityMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const userID = "6f1c3c1e-2a7b-4d1e-9a43-0c2f5b7d8e91"
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		secret string
+		req    func() *http.Request
+		want   int
+	}{
+		{"valid", testIdentitySecret, func() *http.Request {
+			return identityRequest(userID, "user", "", "192.0.2.1", now)
+		}, http.StatusOK},
+		{"tampered user", testIdentitySecret, func() *http.Request {
+			r := identityRequest(userID, "user", "", "192.0.2.1", now)
+			r.Header.Set("X-User-ID", "0b5e8a1f-3c2d-4e6f-8a9b-1c2d3e4f5a6b")
+			return r
+		}, http.StatusUnauthorized},
+		{"tampered role", testIdentitySecret, func() *http.Request {
+			r := identityRequest(userID, "user", "", "192.0.2.1", now)
+			r.Header.Set("X-User-Role", "admin")
+			return r
+		}, http.StatusUnauthorized},
+		{"tampered client ip", testIdentitySecret, func() *http.Request {
+			r := identityRequest(userID, "user", "", "192.0.2.1", now)
+			r.Header.Set("X-Client-IP", "203.0.113.9")
+			return r
+		}, http.StatusUnauthorized},
+		{"expired", testIdentitySecret, func() *http.Request {
+			return identityRequest(userID, "user", "", "192.0.2.1", now.Add(-2*identityMaxSkew))
+		}, http.StatusUnauthorized},
+		{"from the future", testIdentitySecret, func() *http.Request {
+			return identityRequest(userID, "user", "", "192.0.2.1", now.Add(2*identityMaxSkew))
+		}, http.StatusUnauthorized},
+		{"not hex", testIdentitySecret, func() *http.Request {
+			r := identityRequest(userID, "user", "", "192.0.2.1", now)
+			r.Header.Set("X-Identity-Signature", "zz")
+			return r
+		}, http.StatusUnauthorized},
+		{"unsigned", testIdentitySecret, func() *http.Request {
+			r := identityRequest(userID, "user", "", "192.0.2.1", now)
+			r.Header.Del("X-Identity-Signature")
+			return r
+		}, http.StatusUnauthorized},
+		{"no user", testIdentitySecret, func() *http.Request {
+			return identityRequest("", "", "", "192.0.2.1", now)
+		}, http.StatusUnauthorized},
+		{"personal access token", testIdentitySecret, func() *http.Request {
+			return identityRequest(userID, "user", "books:read", "192.0.2.1", now)
+		}, http.StatusForbidden},
+		{"malformed user id", testIdentitySecret, func() *http.Request {
+			return identityRequest("u1", "user", "", "192.0.2.1", now)
+		}, http.StatusUnauthorized},
+		{"no secret configured", "", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User-ID", userID)
+			return r
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(GatewayIdentityMiddleware(tt.secret))
+			r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "%v", c.MustGet("user_id")) })
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req())
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d (%s)", w.Code, tt.want, w.Body)
+			}
+			if w.Code == http.StatusOK && w.Body.String() != userID {
+				t.Errorf("user_id %q, want %q", w.Body, userID)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+-- failed login counters, keyed by "email:<address>" or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key TEXT PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NULL
+);
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- one-time 2FA recovery codes, stored as SHA-256
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes (user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL, -- SHA-256 of the token
+    scopes TEXT NOT NULL,            -- space separated, e.g. "books:read books:write"
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user ON personal_access_tokens (user_id);
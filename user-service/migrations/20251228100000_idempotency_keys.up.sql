@@ -0,0 +1,15 @@
+-- responses to requests made with an Idempotency-Key, replayed on retry;
+-- status is 0 while the first request is still running
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope TEXT NOT NULL,        -- the user, empty for anonymous endpoints
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,  -- SHA-256 of method, path and body
+    status INT NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT '',
+    body BYTEA NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires_at);
@@ -0,0 +1,3 @@
+-- last TOTP time step accepted per user, so a code can't be used twice
+-- within its validity window
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NULL;
@@ -1,23 +1,120 @@
 package util
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-func GenerateJWT(userID uuid.UUID, secret string) (string, time.Time, error) {
+// Claims are the access token claims. Scope is only present on the
+// short-lived tokens minted for personal access tokens and limits what the
+// bearer may do.
+type Claims struct {
+	Role  string `json:"role,omitempty"`
+	Scope string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func GenerateJWT(userID uuid.UUID, role string, secret string) (string, time.Time, error) {
 	now := time.Now().UTC()
 	exp := now.Add(24 * time.Hour)
 
+	claims := Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(secret))
+	return signed, exp, err
+}
+
+// GenerateScopedJWT issues an access token restricted to scopes, used when
+// the gateway exchanges a personal access token.
+func GenerateScopedJWT(userID uuid.UUID, scopes []string, exp time.Time, secret string) (string, error) {
+	now := time.Now().UTC()
+
+	claims := Claims{
+		Scope: strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+const mfaTokenTTL = 5 * time.Minute
+
+// MFAChallenge is a validated MFA challenge token. ID is unique per token
+// and lets the caller redeem it only once.
+type MFAChallenge struct {
+	UserID    uuid.UUID
+	ID        string
+	ExpiresAt time.Time
+}
+
+// GenerateMFAToken issues the short-lived challenge token handed out after a
+// correct password when the account has 2FA enabled. It is signed with a key
+// derived from secret, so it never validates as an access token anywhere
+// else that knows the shared JWT secret.
+func GenerateMFAToken(userID uuid.UUID, secret string) (string, time.Time, error) {
+	now := time.Now().UTC()
+	exp := now.Add(mfaTokenTTL)
+
 	claims := jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
 		Subject:   userID.String(),
+		Audience:  jwt.ClaimStrings{"mfa"},
 		IssuedAt:  jwt.NewNumericDate(now),
 		ExpiresAt: jwt.NewNumericDate(exp),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(secret))
+	signed, err := token.SignedString(mfaKey(secret))
 	return signed, exp, err
 }
+
+// ParseMFAToken validates a challenge token's signature and expiry.
+func ParseMFAToken(tokenString, secret string) (*MFAChallenge, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return mfaKey(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience("mfa"),
+		jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, errors.New("mfa token has no id")
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallenge{UserID: userID, ID: claims.ID, ExpiresAt: claims.ExpiresAt.Time}, nil
+}
+
+func mfaKey(secret string) []byte {
+	return DeriveKey(secret, "booklog-mfa-challenge")
+}
+
+// DeriveKey derives a key for one purpose from the JWT secret, so nothing
+// signed for that purpose can pass as an access token or the other way round.
+func DeriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30 // seconds
+	totpSkew   = 1  // accepted steps either side of now, for clock drift
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160-bit base32 secret (RFC 4226 §4).
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI builds the otpauth:// URI authenticator apps read from a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// ValidateTOTP checks a 6-digit code against secret (RFC 6238) and returns
+// the time step it matched. A code stays valid for 2*totpSkew+1 steps, so
+// callers must remember the step to refuse it a second time.
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	step := now.Unix() / totpPeriod
+	matched, ok := int64(0), false
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		// no early return, so timing doesn't reveal which step matched
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step+i))), []byte(code)) == 1 {
+			matched, ok = step+i, true
+		}
+	}
+	return matched, ok
+}
+
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
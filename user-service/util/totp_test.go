@@ -0,0 +1,69 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// base32 of the RFC 6238 SHA-1 test secret "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to our 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("ValidateTOTP(%q) at %d: rejected", tt.code, tt.unix)
+			continue
+		}
+		if want := tt.unix / totpPeriod; step != want {
+			t.Errorf("ValidateTOTP(%q) at %d: step %d, want %d", tt.code, tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	// "081804" belongs to step 37037036
+	at := time.Unix(1111111109, 0)
+	tests := []struct {
+		name     string
+		now      time.Time
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", at, "081804", 37037036, true},
+		{"one step late", at.Add(totpPeriod * time.Second), "081804", 37037036, true},
+		{"one step early", at.Add(-totpPeriod * time.Second), "081804", 37037036, true},
+		{"two steps late", at.Add(2 * totpPeriod * time.Second), "081804", 0, false},
+		{"surrounding spaces", at, " 081804 ", 37037036, true},
+		{"wrong code", at, "081805", 0, false},
+		{"too short", at, "81804", 0, false},
+		{"too long", at, "0081804", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, tt.code, tt.now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("got (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPBadSecret(t *testing.T) {
+	if _, ok := ValidateTOTP("not base32!", "287082", time.Unix(59, 0)); ok {
+		t.Error("accepted a code for an undecodable secret")
+	}
+}